@@ -0,0 +1,84 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// allocationCodes matches the codes of diagnostics that explain heap allocations.
+var allocationCodes = regexp.MustCompile(`^(escape|escapes|newobject.*|iteration-variable-to-heap)$`)
+
+// allocSampleType returns the profile sample type for -alloc, or "" if
+// -alloc was not specified.
+func allocSampleType() (string, error) {
+	switch allocMode {
+	case "":
+		return "", nil
+	case "space", "objects":
+		return "alloc_" + allocMode, nil
+	}
+	return "", fmt.Errorf("-alloc=%s, expected space or objects", allocMode)
+}
+
+// formatAmount formats an amount of a profile's samples, e.g., 1.5MB of alloc_space.
+func formatAmount(v float64, sampleType string) string {
+	if !strings.HasSuffix(sampleType, "_space") {
+		return fmt.Sprintf("%.0f", v)
+	}
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f%s", v, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", v, units[i])
+}
+
+// reportAllocations prints the hot allocation sites of r, in decreasing
+// order of bytes or objects allocated, each with the diagnostics that
+// explain why it allocates, and their explanations.
+func reportAllocations(r *Report) {
+	hs := append([]*Hotspot(nil), r.Hotspots...)
+	sort.SliceStable(hs, func(i, j int) bool { return hs[i].Samples > hs[j].Samples })
+
+	tab := "        "
+	if r.Cutoff != "" {
+		fmt.Printf("Hot spots: %s\n", r.Cutoff)
+	}
+	fmt.Printf("Allocation sites by %s:\n", r.SampleType)
+	for _, h := range hs {
+		fmt.Printf("%8s %5.1f%%, %s:%d)%s\n", formatAmount(h.Samples, r.SampleType), h.Percent, shorten(h.Position.File), h.Position.Line, statistics(h.item))
+		for _, il := range h.Inlines {
+			fmt.Printf("%12s(inline) %s:%d\n", tab, shorten(il.File), il.Line)
+		}
+		if len(h.Diagnostics) == 0 {
+			fmt.Printf("%8s(no escape diagnostics)\n", tab)
+			continue
+		}
+		for _, m := range h.Diagnostics {
+			nearby := ""
+			if m.Nearness != exact {
+				nearby = m.Nearness + " "
+			}
+			fmt.Printf("%8s%s (at %sline %d)\n", tab, describeKey(m.Code, m.Message), nearby, m.Position.Line)
+			for _, il := range m.Inlines {
+				fmt.Printf("%16s(inline) %s:%d\n", tab, shorten(il.File), il.Line)
+			}
+			for _, e := range m.Explanations {
+				fmt.Printf("%12s%s:%d, %s\n", tab, shorten(e.File), e.Line, e.Message)
+				for _, il := range e.Inlines {
+					fmt.Printf("%18s(inline) %s:%d\n", tab, shorten(il.File), il.Line)
+				}
+			}
+		}
+	}
+}
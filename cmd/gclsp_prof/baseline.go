@@ -0,0 +1,161 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// baselineVersion is the version of the baseline file format.
+const baselineVersion = 1
+
+// Baseline is a record of the hot diagnostics of a program, for detecting
+// new ones later (see -write-baseline and -baseline).
+type Baseline struct {
+	Version  int              `json:"version"`
+	Findings []*BaselineEntry `json:"findings"`
+}
+
+// BaselineEntry identifies a hot diagnostic in a way that survives edits that
+// move it: by its file (relative to the current directory, if possible), the
+// function containing it, and its line offset within that function.  Line is
+// recorded for reference, and used for matching only if the function is unknown.
+type BaselineEntry struct {
+	File     string  `json:"file"`
+	Function string  `json:"function,omitempty"`
+	Offset   int     `json:"offset"`
+	Line     int64   `json:"line"`
+	Code     string  `json:"code"`
+	Message  string  `json:"message,omitempty"`
+	Percent  float64 `json:"percent"`
+}
+
+// baselineEntries returns the hot diagnostics of r as baseline entries.
+func baselineEntries(r *Report) []*BaselineEntry {
+	var result []*BaselineEntry
+	for _, rk := range hotDiagnostics(r) {
+		m := rk.match
+		e := &BaselineEntry{
+			File:    relative(m.Position.File),
+			Line:    m.Position.Line,
+			Code:    m.Code,
+			Message: m.Message,
+			Percent: rk.percent,
+		}
+		if pf := parseSource(m.Position.File); pf != nil {
+			if fn := enclosingFunc(pf.pathTo(int(m.Position.Line), 0)); fn != nil {
+				e.Function = pf.funcName(fn)
+				e.Offset = int(m.Position.Line) - pf.lineOf(fn)
+			}
+		}
+		result = append(result, e)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].File != result[j].File {
+			return result[i].File < result[j].File
+		}
+		return result[i].Line < result[j].Line
+	})
+	return result
+}
+
+func writeBaseline(r *Report, file string) error {
+	b, err := json.MarshalIndent(&Baseline{Version: baselineVersion, Findings: baselineEntries(r)}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(b, '\n'), 0666)
+}
+
+func readBaseline(file string) (*Baseline, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	bl := &Baseline{}
+	if err := json.Unmarshal(b, bl); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if bl.Version != baselineVersion {
+		return nil, fmt.Errorf("%s: baseline version %d, expected %d", file, bl.Version, baselineVersion)
+	}
+	return bl, nil
+}
+
+type entryKey struct {
+	file, function, code, message string
+}
+
+func (e *BaselineEntry) key() entryKey {
+	return entryKey{e.File, e.Function, e.Code, e.Message}
+}
+
+// where returns the position of e used for pairing entries with the same key.
+func (e *BaselineEntry) where() int64 {
+	if e.Function == "" {
+		return e.Line
+	}
+	return int64(e.Offset)
+}
+
+// compareBaseline returns the entries of current that are not in the
+// baseline, and the entries of the baseline that are no longer current.
+// Entries with the same file, function, code and message are paired
+// closest offsets (or lines) first, so that moving code neither adds nor
+// removes findings, but adding a second, similar, diagnostic to the same
+// function does.
+func compareBaseline(baseline, current []*BaselineEntry) (added, removed []*BaselineEntry) {
+	key := func(e *BaselineEntry) entryKey { return e.key() }
+	where := func(e *BaselineEntry) int64 { return e.where() }
+	paired, used := pairClosest(current, baseline, key, where)
+	for i, e := range current {
+		if !paired[i] {
+			added = append(added, e)
+		}
+	}
+	for j, e := range baseline {
+		if !used[j] {
+			removed = append(removed, e)
+		}
+	}
+	return
+}
+
+// reportBaseline prints the hot diagnostics of r that are not in the baseline
+// in file, and those that are no longer found, and returns the number of new ones.
+func reportBaseline(r *Report, file string) (int, error) {
+	bl, err := readBaseline(file)
+	if err != nil {
+		return 0, err
+	}
+	added, removed := compareBaseline(bl.Findings, baselineEntries(r))
+	describe := func(e *BaselineEntry) string {
+		s := e.Code
+		if e.Message != "" {
+			s += ", " + e.Message
+		}
+		s += fmt.Sprintf(" at %s:%d", e.File, e.Line)
+		if e.Function != "" {
+			s += fmt.Sprintf(" (%s+%d)", e.Function, e.Offset)
+		}
+		return s
+	}
+	if len(added) > 0 {
+		fmt.Printf("New hot diagnostics, not in baseline %s:\n", file)
+		for _, e := range added {
+			fmt.Printf("%6.1f%%  %s\n", e.Percent, describe(e))
+		}
+	}
+	if len(removed) > 0 {
+		fmt.Printf("Baseline diagnostics no longer found (consider -write-baseline):\n")
+		for _, e := range removed {
+			fmt.Printf("%6.1f%%  %s\n", e.Percent, describe(e))
+		}
+	}
+	return len(added), nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestCompareBaseline(t *testing.T) {
+	entry := func(function string, offset int, line int64, code string) *BaselineEntry {
+		return &BaselineEntry{File: "foo.go", Function: function, Offset: offset, Line: line, Code: code}
+	}
+	baseline := []*BaselineEntry{
+		entry("transpose", 4, 37, "isInBounds"),
+		entry("transpose", 5, 38, "isInBounds"),
+		entry("colGets", 6, 94, "isInBounds"),
+		entry("", 0, 120, "nilcheck"),
+	}
+	current := []*BaselineEntry{
+		entry("transpose", 4, 47, "isInBounds"), // moved down 10 lines
+		entry("transpose", 5, 48, "isInBounds"),
+		entry("transpose", 9, 52, "isInBounds"), // a third, new
+		entry("rowGets", 6, 82, "isInBounds"),   // new
+		entry("", 0, 120, "nilcheck"),           // no function, matched by line
+	}
+	added, removed := compareBaseline(baseline, current)
+	if len(added) != 2 || added[0] != current[2] || added[1] != current[3] {
+		t.Errorf("added = %v, want transpose+9 and rowGets+6", added)
+	}
+	if len(removed) != 1 || removed[0] != baseline[2] {
+		t.Errorf("removed = %v, want colGets+6", removed)
+	}
+}
+
+func TestCompareBaselineInsertedAbove(t *testing.T) {
+	entry := func(offset int, line int64) *BaselineEntry {
+		return &BaselineEntry{File: "foo.go", Function: "transpose", Offset: offset, Line: line, Code: "isInBounds"}
+	}
+	baseline := []*BaselineEntry{entry(5, 38)}
+	// A new bounds check two lines above the unchanged one comes first, but
+	// must not take its place in the baseline.
+	current := []*BaselineEntry{entry(3, 36), entry(5, 38)}
+	added, removed := compareBaseline(baseline, current)
+	if len(added) != 1 || added[0] != current[0] {
+		t.Errorf("added = %v, want transpose+3", added)
+	}
+	if len(removed) != 0 {
+		t.Errorf("removed = %v, want none", removed)
+	}
+}
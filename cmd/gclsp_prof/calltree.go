@@ -0,0 +1,282 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"regexp"
+	"strings"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+	"github.com/dr2chase/gc-lsp-tools/prof"
+)
+
+// predeclaredTypes are the predeclared type names, whose "calls" are conversions.
+var predeclaredTypes = map[string]bool{
+	"any": true, "bool": true, "byte": true, "comparable": true, "complex64": true, "complex128": true,
+	"error": true, "float32": true, "float64": true, "int": true, "int8": true, "int16": true, "int32": true,
+	"int64": true, "rune": true, "string": true, "uint": true, "uint8": true, "uint16": true, "uint32": true,
+	"uint64": true, "uintptr": true,
+}
+
+// maxCallTreeDepth limits how deeply the call tree follows inlining.
+const maxCallTreeDepth = 10
+
+// callTree describes the calls in hot functions, for -call-tree.
+type callTree struct {
+	inl     map[fileLine]*lsp.Diagnostic   // canInlineFunction and cannotInlineFunction, by function
+	atCall  map[fileLine][]*lsp.Diagnostic // inlineCall and cannotInlineCall, by call
+	funcs   map[string]*prof.FuncItem
+	calls   map[fileLine][]*prof.CallItem // by caller
+	visited map[string]bool               // the functions being expanded, to stop at recursion
+}
+
+// calledName returns the name of the function called by call, as written
+// (the selected name, for a method or qualified function), or "" if call
+// is a call of a builtin, a conversion to a predeclared type, or otherwise
+// not of a named function.
+func calledName(call *ast.CallExpr) string {
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		if builtins[fun.Name] || predeclaredTypes[fun.Name] {
+			return ""
+		}
+		return fun.Name
+	case *ast.SelectorExpr:
+		return fun.Sel.Name
+	case *ast.IndexExpr: // generic, F[T](...), unless the index cannot be a type
+		if _, ok := fun.Index.(*ast.BasicLit); ok {
+			return ""
+		}
+		return calledName(&ast.CallExpr{Fun: fun.X})
+	case *ast.IndexListExpr:
+		return calledName(&ast.CallExpr{Fun: fun.X})
+	}
+	return ""
+}
+
+// names reports whether the profile's function name full could be the
+// function named short in the source.
+func names(full, short string) bool {
+	full = withoutTypeArgs(full)
+	return full == short || strings.HasSuffix(full, "."+short)
+}
+
+// withoutTypeArgs returns the profile's function name full without the
+// type arguments, e.g. "[...]", of generic functions and receiver types.
+func withoutTypeArgs(full string) string {
+	var sb strings.Builder
+	depth := 0
+	for _, r := range full {
+		switch {
+		case r == '[':
+			depth++
+		case r == ']' && depth > 0:
+			depth--
+		case depth == 0:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// namesFunc reports whether the profile's function name full is that of
+// fn, the callee of a call in pf, as found by calledFunc.
+// Unlike names, it tells apart methods of different types, and functions
+// of different packages.
+func namesFunc(full string, fn *types.Func, pf *parsedFile) bool {
+	name := fn.Name()
+	if recv := fn.Type().(*types.Signature).Recv(); recv != nil {
+		t, ptr := recv.Type(), false
+		if p, ok := t.(*types.Pointer); ok {
+			t, ptr = p.Elem(), true
+		}
+		n, ok := t.(*types.Named)
+		if !ok {
+			return names(full, name)
+		}
+		name = n.Obj().Name() + "." + name
+		if ptr {
+			name = "(*" + strings.Replace(name, ".", ").", 1)
+		}
+	}
+	full = withoutTypeArgs(full)
+	tp := typeCheck(pf.name)
+	if _, local := tp.files[tp.files[pf.name].fset.Position(fn.Pos()).Filename]; !local && fn.Pkg() != nil {
+		path := fn.Pkg().Path()
+		i := strings.LastIndex(path, "/") + 1
+		return full == path[:i]+strings.ReplaceAll(path[i:], ".", "%2e")+"."+name
+	}
+	// The package of pf was type checked under its name, not its path, so
+	// full need only be some package path and name.  In symbol names, dots
+	// in the last element of a package path are escaped, as %2e.
+	if !strings.HasSuffix(full, "."+name) {
+		return false
+	}
+	path := full[:len(full)-len(name)-1]
+	return !strings.ContainsAny(path[strings.LastIndex(path, "/")+1:], ".()")
+}
+
+// calleeDiagnostic returns the inlinability diagnostic of the function
+// called by call in pf, given its name in the profile (if it is there),
+// or else as found by type checking (see calleeDecl), or else by its name
+// short among the functions declared in pf.
+func (t *callTree) calleeDiagnostic(profileName, short string, pf *parsedFile, call *ast.CallExpr) (file string, line int64, d *lsp.Diagnostic) {
+	if f := t.funcs[profileName]; f != nil && f.StartLine != 0 {
+		return f.SourceFile, f.StartLine, t.inl[fileLine{f.SourceFile, f.StartLine}]
+	}
+	if k, ok := calleeDecl(pf, call); ok {
+		return k.file, k.line, t.inl[k]
+	}
+	for _, decl := range pf.file.Decls {
+		if fd, ok := decl.(*ast.FuncDecl); ok && fd.Recv == nil && fd.Name.Name == short {
+			line := int64(pf.lineOf(fd))
+			return pf.name, line, t.inl[fileLine{pf.name, line}]
+		}
+	}
+	return "", 0, nil
+}
+
+// print prints the calls in the function declared at file:line, indented by depth,
+// and, for those that were inlined, the calls in the inlined function.
+func (t *callTree) print(name, file string, line int64, depth int) {
+	pf := parseSource(file)
+	if pf == nil || depth > maxCallTreeDepth || t.visited[name] {
+		return
+	}
+	fn := funcAt(pf, int(line))
+	if fn == nil {
+		return
+	}
+	t.visited[name] = true
+	defer delete(t.visited, name)
+
+	indent := strings.Repeat("    ", depth)
+	ast.Inspect(fn, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.FuncLit); ok && lit != fn {
+			return false // a separate function
+		}
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		short := calledName(call)
+		if short == "" {
+			return true
+		}
+		callLine := int64(pf.lineOf(call))
+
+		// What the profile says about this call
+		percent, inlined, called := 0.0, false, ""
+		fn := calledFunc(pf, call)
+		for _, c := range t.calls[fileLine{file, callLine}] {
+			if fn != nil && namesFunc(c.Callee, fn, pf) || fn == nil && names(c.Callee, short) {
+				percent += c.CumPercent
+				inlined = inlined || c.Inlined
+				called = c.Callee
+			}
+		}
+
+		// What the diagnostics say
+		calleeFile, calleeLine, d := t.calleeDiagnostic(called, short, pf, call)
+		status := "not known whether inlined"
+		if cost, ok := inlineCost(d); ok {
+			status = fmt.Sprintf("inlinable (cost %d)", cost)
+		} else if d != nil {
+			status = "not inlined: " + d.Message
+		}
+		if called != "" {
+			if inlined {
+				status = "inlined"
+				if cost, ok := inlineCost(d); ok {
+					status = fmt.Sprintf("inlined (cost %d)", cost)
+				}
+			} else if d == nil || d.Code != "cannotInlineFunction" {
+				status = "not inlined"
+			}
+		}
+		for _, cd := range t.atCall[fileLine{file, callLine}] {
+			if !strings.Contains(cd.Message, short) {
+				continue
+			}
+			if cd.Code == "inlineCall" {
+				inlined, status = true, "inlined"
+			} else {
+				inlined, status = false, "not inlined: "+cd.Message
+			}
+		}
+		callee := ""
+		if called != "" {
+			callee = " -> " + called
+		} else {
+			called = short
+		}
+
+		weight := ""
+		if percent > 0 {
+			weight = fmt.Sprintf("%5.1f%%", percent)
+		}
+		fmt.Printf("%6s %sline %d: %s%s, %s\n", weight, indent, callLine, pf.exprString(call.Fun), callee, status)
+		if (inlined || strings.HasPrefix(status, "inlinable")) && calleeFile != "" {
+			t.print(called, calleeFile, calleeLine, depth+1)
+		}
+		return true
+	})
+}
+
+// reportCallTree prints, for each hot function (by cumulative samples in
+// profiles) whose name matches re, the calls it contains, whether each was
+// inlined, with the reason if not, and their weights.  Calls in inlined
+// functions are shown beneath the call that was inlined, recursively.
+func reportCallTree(re *regexp.Regexp, profiles []string, byFile map[string]*lsp.CompilerDiagnostics) error {
+	opts := prof.Options{Binary: binary, SampleType: sampleType, Verbose: int(verbose)}
+	funcs, calls, err := prof.Cumulative(profiles, opts)
+	if err != nil {
+		return err
+	}
+	t := &callTree{
+		inl:     inlineDiagnostics(byFile),
+		atCall:  make(map[fileLine][]*lsp.Diagnostic),
+		funcs:   make(map[string]*prof.FuncItem),
+		calls:   make(map[fileLine][]*prof.CallItem),
+		visited: make(map[string]bool),
+	}
+	for file, cd := range byFile {
+		for _, d := range cd.Diagnostics {
+			if d.Code == "inlineCall" || d.Code == "cannotInlineCall" {
+				k := fileLine{file, int64(d.Range.Start.Line)}
+				t.atCall[k] = append(t.atCall[k], d)
+			}
+		}
+	}
+	for _, f := range funcs {
+		f.SourceFile, _ = cachedLocalPath(f.SourceFile)
+		t.funcs[f.Name] = f
+	}
+	for _, c := range calls {
+		c.Caller.SourceFile, _ = cachedLocalPath(c.Caller.SourceFile)
+		k := fileLine{c.Caller.SourceFile, c.Caller.Line}
+		t.calls[k] = append(t.calls[k], c)
+	}
+
+	if cutoff != "" {
+		fmt.Printf("Hot spots: %s\n", cutoff)
+	}
+	found := false
+	for _, f := range funcs {
+		if f.CumPercent < threshold || !re.MatchString(f.Name) || byFile[f.SourceFile] == nil {
+			continue
+		}
+		found = true
+		fmt.Printf("%5.1f%%, %s %s:%d\n", f.CumPercent, f.Name, shorten(f.SourceFile), f.StartLine)
+		t.print(f.Name, f.SourceFile, f.StartLine, 1)
+	}
+	if !found {
+		fmt.Printf("No hot functions with diagnostics match %s\n", re)
+	}
+	return nil
+}
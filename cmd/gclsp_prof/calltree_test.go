@@ -0,0 +1,190 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+	"github.com/dr2chase/gc-lsp-tools/prof"
+)
+
+func TestCalledName(t *testing.T) {
+	tests := map[string]string{
+		"f(x)":             "f",
+		"p.F(x)":           "F",
+		"t.m.M()":          "M",
+		"F[int](x)":        "F",
+		"p.G[int, bool]()": "G",
+		"len(x)":           "",
+		"int64(x)":         "",
+		"func() {}()":      "",
+		"fs[0]()":          "",
+	}
+	for src, want := range tests {
+		e, err := parser.ParseExpr(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := calledName(e.(*ast.CallExpr)); got != want {
+			t.Errorf("calledName(%s) = %q, want %q", src, got, want)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	tests := []struct {
+		full, short string
+		want        bool
+	}{
+		{"example.com/p.F", "F", true},
+		{"main.F", "F", true},
+		{"F", "F", true},
+		{"example.com/p.(*T).M", "M", true},
+		{"example.com/p.T.M", "M", true},
+		{"example.com/p.Map[...]", "Map", true},
+		{"example.com/p.(*List[...]).Push", "Push", true},
+		{"example.com/p.FF", "F", false},
+		{"example.com/p.F.func1", "F", false},
+	}
+	for _, tc := range tests {
+		if got := names(tc.full, tc.short); got != tc.want {
+			t.Errorf("names(%q, %q) = %v, want %v", tc.full, tc.short, got, tc.want)
+		}
+	}
+}
+
+// callTreeSource is a package in which calls of two methods named Get
+// are on the same line, and a function in another file is called.
+var callTreeSource = map[string]string{
+	"a.go": `package p
+
+type T struct{ n int }
+
+func (t *T) Get() int { return t.n }
+
+type U struct{ n int }
+
+func (u U) Get() int { return u.n + 1 }
+
+func hot(t *T, u U) int {
+	return t.Get() + u.Get() + helper(1)
+}
+`,
+	"b.go": `package p
+
+func helper(x int) int { return x * 2 }
+`,
+}
+
+func TestCallTree(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range callTreeSource {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b := filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")
+
+	// namesFunc tells the two Gets apart.
+	pf := parseSource(a)
+	calls := nodesOnLine[*ast.CallExpr](pf, 12)
+	if len(calls) != 3 {
+		t.Fatalf("%d calls on line 12, want 3", len(calls))
+	}
+	for _, tc := range []struct {
+		call int // t.Get, u.Get, helper
+		full string
+		want bool
+	}{
+		{0, "example.com/p.(*T).Get", true},
+		{0, "example.com/p.U.Get", false},
+		{0, "example.com/p.(*U).Get", false},
+		{0, "example.com/p.T.Get", false},
+		{1, "example.com/p.U.Get", true},
+		{1, "example.com/p.(*T).Get", false},
+		{2, "example.com/p.helper", true},
+		{2, "main.helper", true},
+		{2, "example.com/p.(*T).helper", false},
+		{2, "example.com/p.hot.helper", false},
+	} {
+		call := calls[tc.call]
+		if got := namesFunc(tc.full, calledFunc(pf, call), pf); got != tc.want {
+			t.Errorf("namesFunc(%q, %s) = %v, want %v", tc.full, pf.exprString(call.Fun), got, tc.want)
+		}
+	}
+
+	diag := func(code, message string) *lsp.Diagnostic {
+		return &lsp.Diagnostic{Code: code, Message: message}
+	}
+	funcs := []*prof.FuncItem{
+		{Name: "example.com/p.hot", SourceFile: a, StartLine: 11, CumPercent: 50},
+		{Name: "example.com/p.(*T).Get", SourceFile: a, StartLine: 5, CumPercent: 20},
+		{Name: "example.com/p.U.Get", SourceFile: a, StartLine: 9, CumPercent: 30},
+	}
+	tree := &callTree{
+		inl: map[fileLine]*lsp.Diagnostic{
+			{a, 5}: diag("canInlineFunction", "cost: 4"),
+			{a, 9}: diag("cannotInlineFunction", "marked go:noinline"),
+			{b, 3}: diag("canInlineFunction", "cost: 6"),
+		},
+		atCall: make(map[fileLine][]*lsp.Diagnostic),
+		funcs:  make(map[string]*prof.FuncItem),
+		calls: map[fileLine][]*prof.CallItem{{a, 12}: {
+			{Caller: prof.FileLine{SourceFile: a, Line: 12}, Callee: "example.com/p.(*T).Get", Inlined: true, CumPercent: 20},
+			{Caller: prof.FileLine{SourceFile: a, Line: 12}, Callee: "example.com/p.U.Get", CumPercent: 30},
+		}},
+		visited: make(map[string]bool),
+	}
+	for _, f := range funcs {
+		tree.funcs[f.Name] = f
+	}
+
+	got := captureStdout(t, func() { tree.print("example.com/p.hot", a, 11, 1) })
+	want := ` 20.0%     line 12: t.Get -> example.com/p.(*T).Get, inlined (cost 4)
+ 30.0%     line 12: u.Get -> example.com/p.U.Get, not inlined: marked go:noinline
+           line 12: helper, inlinable (cost 6)
+`
+	if got != want {
+		t.Errorf("call tree:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// captureStdout returns what f prints on standard output.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- string(b)
+	}()
+	f()
+	os.Stdout = stdout
+	w.Close()
+	return <-done
+}
+
+func TestWithoutTypeArgs(t *testing.T) {
+	for full, want := range map[string]string{
+		"example.com/p.Map[...]":                       "example.com/p.Map",
+		"example.com/p.(*List[...]).Push":              "example.com/p.(*List).Push",
+		"example.com/p.F[go.shape.int,go.shape.[]int]": "example.com/p.F",
+		"example.com/p.F":                              "example.com/p.F",
+	} {
+		if got := withoutTypeArgs(full); got != want {
+			t.Errorf("withoutTypeArgs(%q) = %q, want %q", full, got, want)
+		}
+	}
+}
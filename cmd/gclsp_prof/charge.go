@@ -0,0 +1,78 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dr2chase/gc-lsp-tools/prof"
+)
+
+// chargeFuncs returns the runtime functions to charge to their callers,
+// from -charge-funcs, or else the prof package's defaults.
+func chargeFuncs() []string {
+	if chargeList == "" {
+		return prof.DefaultCharged
+	}
+	var funcs []string
+	for _, f := range strings.Split(chargeList, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			funcs = append(funcs, f)
+		}
+	}
+	return funcs
+}
+
+// chargedFuncsFor returns the runtime functions whose charged samples
+// a diagnostic with code explains, or nil if none do.
+func chargedFuncsFor(code string) []string {
+	switch {
+	case code == "escape", code == "escapes", code == "iteration-variable-to-heap", strings.HasPrefix(code, "newobject"):
+		return prof.AllocationFuncs
+	case code == "isInBounds", code == "isSliceInBounds":
+		return prof.BoundsCheckFuncs
+	case code == "copy":
+		return prof.CopyFuncs
+	}
+	return nil
+}
+
+// chargedPercents returns the percentage of all samples charged to p from
+// each runtime function, or nil if there were none.
+func chargedPercents(p *prof.ProfileItem) map[string]float64 {
+	if len(p.Charged) == 0 || p.FlatTotal == 0 {
+		return nil
+	}
+	result := make(map[string]float64)
+	for fn, v := range p.Charged {
+		result[fn] = p.FlatPercent * v / p.FlatTotal
+	}
+	return result
+}
+
+// describeCharged describes the samples charged to a hot spot, e.g.,
+// " including 8.1% charged from runtime.newobject".
+func describeCharged(charged map[string]float64) string {
+	if len(charged) == 0 {
+		return ""
+	}
+	var fns []string
+	for fn := range charged {
+		fns = append(fns, fn)
+	}
+	sort.Slice(fns, func(i, j int) bool {
+		if charged[fns[i]] != charged[fns[j]] {
+			return charged[fns[i]] > charged[fns[j]]
+		}
+		return fns[i] < fns[j]
+	})
+	var parts []string
+	for _, fn := range fns {
+		parts = append(parts, fmt.Sprintf("%.1f%% charged from %s", charged[fn], fn))
+	}
+	return " including " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,290 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+	"github.com/dr2chase/gc-lsp-tools/prof"
+)
+
+// artifacts are the files of one -bench run, named basename.{lspdir,prof,bench}.
+type artifacts struct {
+	lspdir, profile, bench string
+}
+
+func artifactsFor(basename string) artifacts {
+	return artifacts{basename + ".lspdir", basename + ".prof", basename + ".bench"}
+}
+
+// saveArtifacts moves the artifacts of a previous run from basename from
+// to basename to, replacing any already there.  If there is nothing at from,
+// nothing is done, and any artifacts at to are left alone.
+// The diagnostics are copied, not moved, because the next run's build may
+// hit in the build cache for unchanged packages, and then the compiler will
+// not write them again.
+func saveArtifacts(from, to string) error {
+	f, t := artifactsFor(from), artifactsFor(to)
+	if _, err := os.Stat(f.profile); err != nil {
+		return nil
+	}
+	for _, p := range [][2]string{{f.lspdir, t.lspdir}, {f.profile, t.profile}, {f.bench, t.bench}} {
+		if err := os.RemoveAll(p[1]); err != nil {
+			return err
+		}
+		if _, err := os.Stat(p[0]); err != nil {
+			continue
+		}
+		var err error
+		if p[0] == f.lspdir {
+			err = copyDir(p[0], p[1])
+		} else {
+			err = os.Rename(p[0], p[1])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyDir copies the directory tree from to to.
+func copyDir(from, to string) error {
+	return filepath.WalkDir(from, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0777)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, b, 0666)
+	})
+}
+
+var benchLine = regexp.MustCompile(`^(Benchmark\S*)\s+\d+\s+([0-9.e+]+) ns/op`)
+
+// readNsPerOp returns the mean ns/op of each benchmark in the
+// go test output in file, and the benchmarks in order of appearance.
+func readNsPerOp(file string) (map[string]float64, []string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m := benchLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		ns, err := strconv.ParseFloat(m[2], 64)
+		if err != nil {
+			continue
+		}
+		if counts[m[1]] == 0 {
+			names = append(names, m[1])
+		}
+		sums[m[1]] += ns
+		counts[m[1]]++
+	}
+	for k := range sums {
+		sums[k] /= float64(counts[k])
+	}
+	return sums, names, scanner.Err()
+}
+
+// readRun reads the profile and diagnostics of a run.
+func readRun(a artifacts) ([]*prof.ProfileItem, map[string]*lsp.CompilerDiagnostics, error) {
+	pi, err := readProfiles([]string{a.profile})
+	if err != nil {
+		return nil, nil, err
+	}
+	byFile, _, err := readDiagnostics(a.lspdir, []string{a.profile})
+	if err != nil {
+		return nil, nil, err
+	}
+	remapProfile(pi, byFile)
+	return pi, byFile, nil
+}
+
+// diagnosticKey identifies "the same" diagnostic across two builds of
+// edited source, where line numbers may have changed.
+type diagnosticKey struct {
+	file, code, message string
+}
+
+func keyOf(m *Match) diagnosticKey {
+	return diagnosticKey{m.Position.File, m.Code, m.Message}
+}
+
+// unmatched returns the hot diagnostics in a that have no counterpart in b.
+// Diagnostics with the same key are paired closest lines first, so that
+// when one of several is gone, the others still pair with their moved selves.
+func unmatched(a, b []*ranked) []*ranked {
+	line := func(rk *ranked) int64 { return rk.match.Position.Line }
+	paired, _ := pairClosest(a, b, func(rk *ranked) diagnosticKey { return keyOf(rk.match) }, line)
+	var result []*ranked
+	for i, rk := range a {
+		if !paired[i] {
+			result = append(result, rk)
+		}
+	}
+	return result
+}
+
+// pairClosest pairs elements of a with elements of b that have the same key,
+// closest (by where) first, and reports which elements of each were paired.
+func pairClosest[T any, K comparable](a, b []T, key func(T) K, where func(T) int64) (aPaired, bPaired []bool) {
+	type pair struct{ i, j int }
+	byKey := make(map[K][]int)
+	for j, e := range b {
+		k := key(e)
+		byKey[k] = append(byKey[k], j)
+	}
+	var pairs []pair
+	for i, e := range a {
+		for _, j := range byKey[key(e)] {
+			pairs = append(pairs, pair{i, j})
+		}
+	}
+	distance := func(p pair) int64 { return abs(where(a[p.i]) - where(b[p.j])) }
+	sort.SliceStable(pairs, func(x, y int) bool { return distance(pairs[x]) < distance(pairs[y]) })
+	aPaired, bPaired = make([]bool, len(a)), make([]bool, len(b))
+	for _, p := range pairs {
+		if !aPaired[p.i] && !bPaired[p.j] {
+			aPaired[p.i], bPaired[p.j] = true, true
+		}
+	}
+	return
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// lineShares returns the flat percentage of each file:line in pi.
+func lineShares(pi []*prof.ProfileItem) map[Position]float64 {
+	shares := make(map[Position]float64)
+	for _, p := range pi {
+		fl := p.FileLine[0]
+		shares[Position{File: fl.SourceFile, Line: fl.Line}] += p.FlatPercent
+	}
+	return shares
+}
+
+// reportComparison prints, for the runs in old and cur, the change in ns/op of
+// each benchmark, the hot diagnostics that disappeared or appeared, and the
+// change in sample share of each line that is hot in either.
+func reportComparison(old, cur artifacts) error {
+	oldPI, oldDiags, err := readRun(old)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", old.profile, err)
+	}
+	newPI, newDiags, err := readRun(cur)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", cur.profile, err)
+	}
+	if len(newPI) == 0 {
+		return fmt.Errorf("no samples in %s", cur.profile)
+	}
+	// For -top or -cover, the new run chooses the threshold for both.
+	if err := chooseThreshold(newPI); err != nil {
+		return err
+	}
+	if cutoff != "" {
+		fmt.Printf("Hot spots: %s\n", cutoff)
+	}
+	oldR, newR := buildReport(oldPI, oldDiags), buildReport(newPI, newDiags)
+
+	oldNs, _, oerr := readNsPerOp(old.bench)
+	newNs, names, nerr := readNsPerOp(cur.bench)
+	if oerr == nil && nerr == nil && len(names) > 0 {
+		fmt.Printf("%-40s %14s %14s %8s\n", "benchmark", "old ns/op", "new ns/op", "delta")
+		for _, n := range names {
+			o, ok := oldNs[n]
+			if !ok {
+				fmt.Printf("%-40s %14s %14.1f\n", n, "-", newNs[n])
+				continue
+			}
+			fmt.Printf("%-40s %14.1f %14.1f %+7.1f%%\n", n, o, newNs[n], 100*(newNs[n]-o)/o)
+		}
+		fmt.Println()
+	}
+
+	oldHot, newHot := hotDiagnostics(oldR), hotDiagnostics(newR)
+	for _, s := range []struct {
+		title string
+		rs    []*ranked
+	}{{"Hot diagnostics that disappeared (old sample percent):", unmatched(oldHot, newHot)},
+		{"Hot diagnostics that appeared (new sample percent):", unmatched(newHot, oldHot)}} {
+		fmt.Println(s.title)
+		if len(s.rs) == 0 {
+			fmt.Printf("%8s(none)\n", "")
+		}
+		for _, rk := range s.rs {
+			m := rk.match
+			what := m.Code
+			if m.Message != "" {
+				what += ", " + m.Message
+			}
+			fmt.Printf("%6.1f%%  %s at %s:%d\n", rk.percent, what, shorten(m.Position.File), m.Position.Line)
+		}
+	}
+
+	oldShares, newShares := lineShares(oldPI), lineShares(newPI)
+	var lines []Position
+	for p, pct := range oldShares {
+		if pct >= threshold || newShares[p] >= threshold {
+			lines = append(lines, p)
+		}
+	}
+	for p, pct := range newShares {
+		if _, ok := oldShares[p]; !ok && pct >= threshold {
+			lines = append(lines, p)
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		di := math.Abs(newShares[lines[i]] - oldShares[lines[i]])
+		dj := math.Abs(newShares[lines[j]] - oldShares[lines[j]])
+		if di != dj {
+			return di > dj
+		}
+		if lines[i].File != lines[j].File {
+			return lines[i].File < lines[j].File
+		}
+		return lines[i].Line < lines[j].Line
+	})
+	fmt.Println("Sample share of hot lines:")
+	fmt.Printf("%7s %7s %7s  %s\n", "old", "new", "delta", "line")
+	for _, p := range lines {
+		o, n := oldShares[p], newShares[p]
+		fmt.Printf("%6.1f%% %6.1f%% %+6.1f  %s:%d\n", o, n, n-o, shorten(p.File), p.Line)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+// emptyProfile returns a CPU profile with no samples.
+func emptyProfile(t *testing.T) []byte {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     10000000,
+	}
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadNsPerOp(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "x.bench")
+	out := `goos: linux
+goarch: amd64
+pkg: example.com/p
+BenchmarkB-8   	 1000000	      1000 ns/op
+BenchmarkA-8   	  500000	      2500 ns/op	     128 B/op	       2 allocs/op
+BenchmarkB-8   	 1000000	      1200 ns/op
+BenchmarkC-8   	100000000	         1.5e+01 ns/op
+BenchmarkD-8   	--- FAIL: BenchmarkD
+PASS
+ok  	example.com/p	3.012s
+`
+	if err := os.WriteFile(file, []byte(out), 0666); err != nil {
+		t.Fatal(err)
+	}
+	ns, names, err := readNsPerOp(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(names, " "), "BenchmarkB-8 BenchmarkA-8 BenchmarkC-8"; got != want {
+		t.Errorf("names %s, want %s", got, want)
+	}
+	for name, want := range map[string]float64{"BenchmarkB-8": 1100, "BenchmarkA-8": 2500, "BenchmarkC-8": 15} {
+		if ns[name] != want {
+			t.Errorf("%s: %g ns/op, want %g", name, ns[name], want)
+		}
+	}
+	if len(ns) != 3 {
+		t.Errorf("%d benchmarks, want 3", len(ns))
+	}
+
+	if _, _, err := readNsPerOp(filepath.Join(t.TempDir(), "missing.bench")); err == nil {
+		t.Errorf("no error for a missing file")
+	}
+}
+
+func TestUnmatched(t *testing.T) {
+	rk := func(file string, line int64, code, message string) *ranked {
+		return &ranked{match: &Match{Code: code, Message: message, Position: Position{File: file, Line: line}}}
+	}
+	show := func(rs []*ranked) string {
+		var s []string
+		for _, r := range rs {
+			s = append(s, fmt.Sprintf("%s:%d %s", r.match.Position.File, r.match.Position.Line, r.match.Code))
+		}
+		return strings.Join(s, ", ")
+	}
+	old := []*ranked{
+		rk("a.go", 10, "isInBounds", ""),
+		rk("a.go", 20, "isInBounds", ""),
+		rk("a.go", 30, "escape", "x escapes to heap"),
+		rk("b.go", 5, "nilcheck", ""),
+	}
+	cur := []*ranked{
+		// Lines moved down by 2; one of the bounds checks is gone.
+		rk("a.go", 22, "isInBounds", ""),
+		rk("a.go", 32, "escape", "x escapes to heap"),
+		// Same code and place, different message.
+		rk("a.go", 32, "escape", "y escapes to heap"),
+		// Same code and line, different file.
+		rk("c.go", 5, "nilcheck", ""),
+	}
+	if got, want := show(unmatched(old, cur)), "a.go:10 isInBounds, b.go:5 nilcheck"; got != want {
+		t.Errorf("disappeared: %s, want %s", got, want)
+	}
+	if got, want := show(unmatched(cur, old)), "a.go:32 escape, c.go:5 nilcheck"; got != want {
+		t.Errorf("appeared: %s, want %s", got, want)
+	}
+	if got := show(unmatched(old, old)); got != "" {
+		t.Errorf("unmatched with itself: %s", got)
+	}
+	if got, want := show(unmatched(old[:1], nil)), "a.go:10 isInBounds"; got != want {
+		t.Errorf("unmatched with nothing: %s, want %s", got, want)
+	}
+}
+
+func TestReportComparisonNoSamples(t *testing.T) {
+	dir := t.TempDir()
+	old, cur := artifactsFor(filepath.Join(dir, "old")), artifactsFor(filepath.Join(dir, "new"))
+	for _, a := range []artifacts{old, cur} {
+		if err := os.Mkdir(a.lspdir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(a.profile, emptyProfile(t), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := []any{top, threshold, cutoff}
+	defer func() { top, threshold, cutoff = saved[0].(int), saved[1].(float64), saved[2].(string) }()
+	top = 5
+	err := reportComparison(old, cur)
+	if err == nil || !strings.Contains(err.Error(), "no samples") {
+		t.Errorf("reportComparison of empty profiles: %v, want an error about no samples", err)
+	}
+}
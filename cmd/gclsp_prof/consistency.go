@@ -0,0 +1,174 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+	"github.com/dr2chase/gc-lsp-tools/prof"
+)
+
+// commitHash matches the commit in a development version, e.g., "devel go1.22-86487adf6a Thu ..."
+var commitHash = regexp.MustCompile(`[0-9a-f]{10,}`)
+
+// sameVersion reports whether two Go version strings, from different sources,
+// name the same toolchain, and whether that could be determined.
+func sameVersion(a, b string) (same, known bool) {
+	if a == "" || b == "" {
+		return false, false
+	}
+	if strings.HasPrefix(a, "devel") != strings.HasPrefix(b, "devel") {
+		return false, true
+	}
+	if strings.HasPrefix(a, "devel") {
+		ha, hb := commitHash.FindString(a), commitHash.FindString(b)
+		if ha == "" || hb == "" {
+			return false, false
+		}
+		n := len(ha)
+		if len(hb) < n {
+			n = len(hb)
+		}
+		return ha[:n] == hb[:n], true
+	}
+	return strings.Fields(a)[0] == strings.Fields(b)[0], true
+}
+
+// checkConsistency returns the reasons, if any, why the diagnostics in byFile
+// might not describe the program that the profiles were collected from:
+// different toolchains or architectures, a binary that is not the profiled
+// one, or sources that changed after they were compiled.
+func checkConsistency(profiles []string, byFile map[string]*lsp.CompilerDiagnostics) []string {
+	var problems []string
+
+	// What the diagnostics say about the build
+	versions, goarchs := make(map[string]bool), make(map[string]bool)
+	for _, cd := range byFile {
+		if h := cd.Header; h != nil {
+			versions[h.GcVersion] = true
+			goarchs[h.Goos+"/"+h.Goarch] = true
+		}
+	}
+	if len(versions) > 1 {
+		problems = append(problems, fmt.Sprintf("diagnostics are from more than one compiler version: %s", strings.Join(sortedKeys(versions), ", ")))
+	}
+
+	// What the profiles, and the profiled binary if it is available, say
+	var builds []*prof.BuildInfo
+	for _, p := range profiles {
+		pbi, err := prof.ProfileBuildInfo(p)
+		if err != nil {
+			continue
+		}
+		bin := binary
+		if bin == "" {
+			bin = pbi.Binary
+		}
+		if bbi, err := prof.BinaryBuildInfo(bin); err == nil {
+			if pbi.BuildID != "" && bbi.BuildID != "" && pbi.BuildID != bbi.BuildID {
+				problems = append(problems, fmt.Sprintf("%s was not the binary profiled in %s (build ID %s, not %s)", bin, p, bbi.BuildID, pbi.BuildID))
+				builds = append(builds, pbi)
+				continue
+			}
+			if pbi.GoVersion == "" {
+				pbi.GoVersion = bbi.GoVersion
+			}
+			pbi.Goos, pbi.Goarch = bbi.Goos, bbi.Goarch
+			if fi, err := os.Stat(bin); err == nil {
+				for file, cd := range byFile {
+					// Diagnostics from recompiling that change do not describe the profiled binary.
+					if si, err := os.Stat(file); err == nil && si.ModTime().After(fi.ModTime()) && !cd.ModTime.Before(si.ModTime()) {
+						problems = append(problems, fmt.Sprintf("%s changed after the profiled binary %s was built", file, bin))
+					}
+				}
+			}
+		}
+		builds = append(builds, pbi)
+	}
+
+	problems = append(problems, buildProblems(builds, versions, goarchs)...)
+
+	// Sources that changed after they were compiled
+	for file, cd := range byFile {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		if fi, err := os.Stat(file); err == nil && !cd.ModTime.IsZero() && fi.ModTime().After(cd.ModTime) {
+			problems = append(problems, fmt.Sprintf("%s changed after it was compiled", file))
+			continue
+		}
+		lines := uint(bytes.Count(src, []byte("\n")) + 1)
+		for _, d := range cd.Diagnostics {
+			if d.Range.End.Line > lines {
+				problems = append(problems, fmt.Sprintf("%s has %d lines, but a diagnostic refers to line %d", file, lines, d.Range.End.Line))
+				break
+			}
+		}
+	}
+
+	sort.Strings(problems)
+	return dedupe(problems)
+}
+
+// buildProblems returns the differences between the compiler versions and
+// GOOS/GOARCHs of the diagnostics and those of the profiled builds.  Either
+// may be unknown, if no diagnostics file has a header or the profile and
+// binary do not say, and then there is nothing to compare.
+func buildProblems(builds []*prof.BuildInfo, versions, goarchs map[string]bool) []string {
+	var problems []string
+	for _, b := range builds {
+		for v := range versions {
+			if same, known := sameVersion(v, b.GoVersion); known && !same {
+				problems = append(problems, fmt.Sprintf("diagnostics are from compiler version %s, but the profiled binary %s was built with %s", v, b.Binary, b.GoVersion))
+			}
+		}
+		if b.Goarch != "" && len(goarchs) > 0 {
+			if arch := b.Goos + "/" + b.Goarch; !goarchs[arch] {
+				problems = append(problems, fmt.Sprintf("diagnostics are for %s, but the profiled binary %s is for %s", strings.Join(sortedKeys(goarchs), ", "), b.Binary, arch))
+			}
+		}
+	}
+	return problems
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// dedupe removes adjacent duplicates from sorted ss.
+func dedupe(ss []string) []string {
+	var result []string
+	for i, s := range ss {
+		if i == 0 || s != ss[i-1] {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// reportConsistency prints the problems found by checkConsistency on standard
+// error, and returns false if they should stop the report (see -check).
+func reportConsistency(profiles []string, byFile map[string]*lsp.CompilerDiagnostics) bool {
+	if check == "off" {
+		return true
+	}
+	problems := checkConsistency(profiles, byFile)
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", p)
+	}
+	return len(problems) == 0 || check != "strict"
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dr2chase/gc-lsp-tools/prof"
+)
+
+func TestSameVersion(t *testing.T) {
+	tests := []struct {
+		a, b        string
+		same, known bool
+	}{
+		{"go1.21.3", "go1.21.3", true, true},
+		{"go1.21.3", "go1.21.4", false, true},
+		{"go1.21.3", "go1.21.3 X:boringcrypto", true, true},
+		{"devel +86487adf6a Thu Nov 7 19:34:56 2019 -0500", "devel go1.14-86487adf6a Thu Nov 7 19:34:56 2019 -0500", true, true},
+		{"devel go1.22-86487adf6a Thu Nov 7", "devel go1.22-0123456789 Fri Nov 8", false, true},
+		{"devel go1.22-86487adf6a Thu Nov 7", "go1.22.0", false, true},
+		{"go1.21.3", "", false, false},
+	}
+	for _, tc := range tests {
+		if same, known := sameVersion(tc.a, tc.b); same != tc.same || known != tc.known {
+			t.Errorf("sameVersion(%q, %q) = %v, %v, want %v, %v", tc.a, tc.b, same, known, tc.same, tc.known)
+		}
+	}
+}
+
+func TestBuildProblems(t *testing.T) {
+	set := func(keys ...string) map[string]bool {
+		m := make(map[string]bool)
+		for _, k := range keys {
+			m[k] = true
+		}
+		return m
+	}
+	build := &prof.BuildInfo{Binary: "foo.test", GoVersion: "go1.21.3", Goos: "linux", Goarch: "amd64"}
+	tests := []struct {
+		name              string
+		builds            []*prof.BuildInfo
+		versions, goarchs map[string]bool
+		want              string
+	}{
+		{"same", []*prof.BuildInfo{build}, set("go1.21.3"), set("linux/amd64"), ""},
+		{"other arch", []*prof.BuildInfo{build}, set("go1.21.3"), set("linux/arm64"),
+			"diagnostics are for linux/arm64, but the profiled binary foo.test is for linux/amd64"},
+		{"other version", []*prof.BuildInfo{build}, set("go1.21.4"), set("linux/amd64"),
+			"diagnostics are from compiler version go1.21.4, but the profiled binary foo.test was built with go1.21.3"},
+		// No diagnostics file has a header.
+		{"no headers", []*prof.BuildInfo{build}, set(), set(), ""},
+		// Neither the profile nor the binary says.
+		{"unknown build", []*prof.BuildInfo{{Binary: "foo.test"}}, set("go1.21.3"), set("linux/amd64"), ""},
+	}
+	for _, tc := range tests {
+		if got := strings.Join(buildProblems(tc.builds, tc.versions, tc.goarchs), "; "); got != tc.want {
+			t.Errorf("%s: %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// typedPackage is the type-checked package containing some source file,
+// for finding the types of expressions at diagnostics.
+type typedPackage struct {
+	files map[string]*parsedFile
+	info  *types.Info
+	sizes types.Sizes
+}
+
+var typedPackages = make(map[string]*typedPackage) // by directory
+
+// typeCheck returns the type-checked package containing file, or nil if
+// it cannot be parsed.  Type errors are ignored; the types that could be
+// determined are still recorded.
+func typeCheck(file string) *typedPackage {
+	dir := filepath.Dir(file)
+	if tp, ok := typedPackages[dir]; ok {
+		return tp
+	}
+	typedPackages[dir] = nil
+
+	fset := token.NewFileSet()
+	parse := func(name string) *parsedFile {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			return nil
+		}
+		f, err := parser.ParseFile(fset, name, src, parser.ParseComments)
+		if err != nil {
+			return nil
+		}
+		return &parsedFile{name: name, fset: fset, file: f, lines: strings.Split(string(src), "\n")}
+	}
+	pf := parse(file)
+	if pf == nil {
+		return nil
+	}
+	tp := &typedPackage{files: map[string]*parsedFile{file: pf}}
+	files := []*ast.File{pf.file}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		name := filepath.Join(dir, e.Name())
+		if name == file || !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		if match, err := build.Default.MatchFile(dir, e.Name()); err != nil || !match {
+			continue
+		}
+		if other := parse(name); other != nil && other.file.Name.Name == pf.file.Name.Name {
+			tp.files[name] = other
+			files = append(files, other.file)
+		}
+	}
+
+	goarch := selectedTarget.Goarch
+	if goarch == "" {
+		goarch = runtime.GOARCH
+	}
+	tp.sizes = types.SizesFor("gc", goarch)
+	tp.info = &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	// Imported objects have positions in fset too, see calleeDecl.
+	conf := types.Config{Importer: importer.ForCompiler(fset, "gc", nil), Sizes: tp.sizes, Error: func(error) {}}
+	conf.Check(pf.file.Name.Name, fset, files, tp.info)
+	typedPackages[dir] = tp
+	return tp
+}
+
+// copiedType returns the type of the value of size bytes copied at
+// file:line:col, or "" if it cannot be determined.  It is the type of the
+// element of a range loop on that line, or else of the first expression
+// of that size in the innermost enclosing expression or statement that
+// has one.
+func copiedType(file string, line, col, size int) string {
+	tp := typeCheck(file)
+	if tp == nil {
+		return ""
+	}
+	pf := tp.files[file]
+	qualifier := func(p *types.Package) string { return p.Name() }
+	sized := func(e ast.Expr) string {
+		t := tp.info.TypeOf(e)
+		if t == nil || tp.sizes.Sizeof(t) != int64(size) {
+			return ""
+		}
+		return types.TypeString(t, qualifier)
+	}
+
+	for _, r := range nodesOnLine[*ast.RangeStmt](pf, line) {
+		if r.Value != nil {
+			if t := sized(r.Value); t != "" {
+				return t
+			}
+		}
+	}
+	path := pf.pathTo(line, col)
+	for i := len(path) - 1; i >= 0; i-- {
+		switch path[i].(type) {
+		case *ast.BlockStmt, *ast.FuncLit, *ast.FuncDecl:
+			return ""
+		}
+		result := ""
+		ast.Inspect(path[i], func(n ast.Node) bool {
+			switch n := n.(type) {
+			case nil, *ast.BlockStmt, *ast.FuncLit:
+				return false
+			case ast.Expr:
+				if result == "" {
+					result = sized(n)
+				}
+			}
+			return result == ""
+		})
+		if result != "" {
+			return result
+		}
+	}
+	return ""
+}
+
+// hotCopy is a copy diagnostic, with the total percentage of the hot spots
+// at exactly its position, and what is copied.
+type hotCopy struct {
+	match   *Match
+	bytes   int
+	percent float64
+	context string // see copyContext
+	typ     string
+}
+
+// innermost returns the innermost position of a hot spot or diagnostic.
+func innermost(outer Position, inlines []Position) Position {
+	if len(inlines) > 0 {
+		return inlines[len(inlines)-1]
+	}
+	return outer
+}
+
+// sameSite reports whether the diagnostic of m is at exactly the position,
+// including inlining, of hot spot h.
+func sameSite(h *Hotspot, m *Match) bool {
+	if len(h.Inlines) != len(m.Inlines) {
+		return false
+	}
+	var mInlines []Position
+	for _, il := range m.Inlines {
+		mInlines = append(mInlines, il.Position)
+	}
+	hp, mp := innermost(h.Position, h.Inlines), innermost(m.Position, mInlines)
+	end := mp.EndLine
+	if end == 0 {
+		end = mp.Line
+	}
+	return hp.File == mp.File && mp.Line <= hp.Line && hp.Line <= end
+}
+
+// hotCopies returns the copy diagnostics of r, each once (the compiler may
+// report the same copy more than once), with the hot spots at exactly their
+// positions, in decreasing order of bytes times percent.
+func hotCopies(r *Report) []*hotCopy {
+	var result []*hotCopy
+	seen := make(map[string]*hotCopy)
+	for _, h := range r.Hotspots {
+		counted := make(map[*hotCopy]bool)
+		for _, m := range h.Diagnostics {
+			if m.Code != "copy" || !sameSite(h, m) {
+				continue
+			}
+			k := identity(m.diagnostic)
+			hc := seen[k]
+			if hc == nil {
+				n, _ := diagnosticBytes(m.Code, m.Message)
+				hc = &hotCopy{match: m, bytes: n}
+				file, line, col := innermostPosition(m)
+				if pf := parseSource(file); pf != nil {
+					hc.context = copyContext(pf, line, col)
+					hc.typ = copiedType(file, line, col, n)
+				}
+				seen[k] = hc
+				result = append(result, hc)
+			}
+			if !counted[hc] {
+				counted[hc] = true
+				hc.percent += h.Percent
+			}
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return float64(result[i].bytes)*result[i].percent > float64(result[j].bytes)*result[j].percent
+	})
+	return result
+}
+
+// reportCopies prints the hot copies of r, in decreasing order of bytes
+// copied times sample percentage, with the type copied, and whether the
+// copy is of a range loop's element, an argument, a result, or an assignment.
+func reportCopies(r *Report) {
+	if r.Cutoff != "" {
+		fmt.Printf("Hot spots: %s\n", r.Cutoff)
+	}
+	hcs := hotCopies(r)
+	fmt.Printf("Hot copies, by bytes copied times sample percent:\n")
+	if len(hcs) == 0 {
+		fmt.Printf("%8s(none)\n", "")
+		return
+	}
+	fmt.Printf("%8s %7s %7s  %-10s  %s\n", "score", "bytes", "percent", "context", "type, position")
+	for _, hc := range hcs {
+		file, line, _ := innermostPosition(hc.match)
+		where := fmt.Sprintf("%s:%d", shorten(file), line)
+		if len(hc.match.Inlines) > 0 {
+			where += fmt.Sprintf(" (inlined at %s:%d)", shorten(hc.match.Position.File), hc.match.Position.Line)
+		}
+		typ := hc.typ
+		if typ == "" {
+			typ = "?"
+		}
+		fmt.Printf("%8.0f %7d %6.1f%%  %-10s  %s, %s\n", float64(hc.bytes)*hc.percent, hc.bytes, hc.percent, hc.context, typ, where)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dr2chase/gc-lsp-tools/layouts"
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+)
+
+// cost is the estimated cost of one missed optimization, in arbitrary units
+// (roughly, nanoseconds per execution).  The per-byte part applies to
+// diagnostics that carry a size, i.e., "copy" (from its "N bytes" message)
+// and "newobject..." (from the size of its type layout).
+type cost struct {
+	base, perByte float64
+}
+
+// costs is the cost table, keyed by diagnostic code.  A code that is not in
+// the table is matched against keys ending in "*" as a prefix (longest first), and otherwise
+// costs nothing.  The defaults can be overridden with -costs=file.
+var costs = map[string]cost{
+	"nilcheck":                   {1, 0},
+	"isInBounds":                 {2, 0},
+	"isSliceInBounds":            {3, 0},
+	"copy":                       {1, 0.125},
+	"escape":                     {25, 0},
+	"escapes":                    {25, 0},
+	"newobject*":                 {20, 0.1},
+	"iteration-variable-to-heap": {25, 0},
+	"cannotInlineCall":           {5, 0},
+	"cannotInlineFunction":       {5, 0},
+}
+
+// readCosts reads a cost table from file, overriding or extending the defaults.
+// Each line is "code base [perByte]"; blank lines and lines starting with # are ignored.
+func readCosts(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return fmt.Errorf("%s:%d: expected 'code base [perByte]', saw %q", file, n, line)
+		}
+		var c cost
+		if c.base, err = strconv.ParseFloat(fields[1], 64); err != nil {
+			return fmt.Errorf("%s:%d: %v", file, n, err)
+		}
+		if len(fields) == 3 {
+			if c.perByte, err = strconv.ParseFloat(fields[2], 64); err != nil {
+				return fmt.Errorf("%s:%d: %v", file, n, err)
+			}
+		}
+		costs[fields[0]] = c
+	}
+	return scanner.Err()
+}
+
+// costFor returns the cost table entry for code.
+func costFor(code string) (cost, bool) {
+	if c, ok := costs[code]; ok {
+		return c, true
+	}
+	// Longest matching prefix wins
+	best, found := "", false
+	for k := range costs {
+		if strings.HasSuffix(k, "*") && strings.HasPrefix(code, k[:len(k)-1]) && len(k) > len(best) {
+			best, found = k, true
+		}
+	}
+	return costs[best], found
+}
+
+// diagnosticBytes returns the size in bytes carried by a diagnostic's message, if any.
+func diagnosticBytes(code, message string) (int, bool) {
+	switch {
+	case code == "copy":
+		var n int
+		if _, err := fmt.Sscanf(message, "%d bytes", &n); err == nil {
+			return n, true
+		}
+	case strings.HasPrefix(code, "newobject"):
+		return typeSize(message)
+	}
+	return 0, false
+}
+
+// typeSize returns the size of a type, given its layout string
+// (see the layouts package), or false if it cannot be parsed.
+func typeSize(t string) (size int, ok bool) {
+	defer func() {
+		if recover() != nil {
+			size, ok = 0, false
+		}
+	}()
+	_, size, align, _, _, _ := layouts.Builtins.Plain(t)
+	if align > 0 {
+		size = (size + align - 1) & -align
+	}
+	return size, true
+}
+
+// estimatedCost returns the estimated cost of a single execution of the missed optimization m.
+func estimatedCost(m *Match) float64 {
+	c, _ := costFor(m.Code)
+	est := c.base
+	if n, ok := diagnosticBytes(m.Code, m.Message); ok {
+		est += c.perByte * float64(n)
+	}
+	return est
+}
+
+// ranked is a diagnostic, its estimated cost, the total sample
+// percentage of the hot spots near it, and the product of the two.
+type ranked struct {
+	match   *Match
+	cost    float64
+	percent float64
+	payoff  float64
+}
+
+// hotDiagnostics returns the diagnostics of r, each once,
+// with the total sample percentage of the hot spots near it.
+func hotDiagnostics(r *Report) []*ranked {
+	var result []*ranked
+	seen := make(map[*lsp.Diagnostic]*ranked)
+	for _, h := range r.Hotspots {
+		for _, m := range h.Diagnostics {
+			rk := seen[m.diagnostic]
+			if rk == nil {
+				rk = &ranked{match: m, cost: m.Cost}
+				seen[m.diagnostic] = rk
+				result = append(result, rk)
+			}
+			rk.percent += h.Percent
+		}
+	}
+	return result
+}
+
+// rankByPayoff returns the diagnostics of r with nonzero cost,
+// each once, in decreasing order of expected payoff.
+func rankByPayoff(r *Report) []*ranked {
+	var result []*ranked
+	for _, rk := range hotDiagnostics(r) {
+		if rk.cost == 0 {
+			continue
+		}
+		rk.payoff = rk.percent * rk.cost * loopWeight(rk.match)
+		result = append(result, rk)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].payoff > result[j].payoff })
+	return result
+}
+
+// reportRanked prints the diagnostics of r in decreasing order of expected payoff.
+func reportRanked(r *Report) {
+	rs := rankByPayoff(r)
+	if len(rs) == 0 {
+		return
+	}
+	if r.Cutoff != "" {
+		fmt.Printf("Hot spots: %s\n", r.Cutoff)
+	}
+	fmt.Printf("Ranked by expected payoff (sample percent x estimated cost, x loop weight for checks):\n")
+	fmt.Printf("%8s %7s %7s  %s\n", "payoff", "cost", "percent", "diagnostic")
+	for _, rk := range rs {
+		m := rk.match
+		what := m.Code
+		if m.Message != "" {
+			what += ", " + m.Message
+		}
+		fmt.Printf("%8.1f %7.2f %6.1f%%  %s at %s:%d%s\n", rk.payoff, rk.cost, rk.percent, what, shorten(m.Position.File), m.Position.Line, describeLoops(m))
+	}
+}
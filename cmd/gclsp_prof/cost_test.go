@@ -0,0 +1,166 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+)
+
+// withCosts runs f with a copy of the default cost table, restoring it after.
+func withCosts(f func()) {
+	saved := costs
+	costs = make(map[string]cost)
+	for k, v := range saved {
+		costs[k] = v
+	}
+	defer func() { costs = saved }()
+	f()
+}
+
+func TestReadCosts(t *testing.T) {
+	tests := []struct {
+		name, text string
+		err        string // "" for success
+		want       map[string]cost
+	}{
+		{
+			name: "comments",
+			text: "# a comment\n\n  # indented\nnilcheck 7\ncopy 2 0.5\n",
+			want: map[string]cost{"nilcheck": {7, 0}, "copy": {2, 0.5}, "escape": {25, 0}},
+		},
+		{
+			name: "duplicates",
+			text: "nilcheck 7\nnilcheck 9 1\n",
+			want: map[string]cost{"nilcheck": {9, 1}},
+		},
+		{
+			name: "new code",
+			text: "bce* 4\n",
+			want: map[string]cost{"bce*": {4, 0}, "isInBounds": {2, 0}},
+		},
+		{name: "one field", text: "nilcheck\n", err: ":1: expected 'code base [perByte]'"},
+		{name: "four fields", text: "# ok\nnilcheck 1 2 3\n", err: ":2: expected 'code base [perByte]'"},
+		{name: "bad base", text: "nilcheck one\n", err: `:1: strconv.ParseFloat: parsing "one"`},
+		{name: "bad perByte", text: "copy 1 x\n", err: `:1: strconv.ParseFloat: parsing "x"`},
+	}
+	for _, tc := range tests {
+		withCosts(func() {
+			file := filepath.Join(t.TempDir(), "costs")
+			if err := os.WriteFile(file, []byte(tc.text), 0666); err != nil {
+				t.Fatal(err)
+			}
+			err := readCosts(file)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), file+tc.err) {
+					t.Errorf("%s: error %v, want %s%s", tc.name, err, file, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+				return
+			}
+			for code, want := range tc.want {
+				if got := costs[code]; got != want {
+					t.Errorf("%s: cost of %s = %v, want %v", tc.name, code, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCostFor(t *testing.T) {
+	withCosts(func() {
+		costs["new*"] = cost{5, 0}
+		costs["newobjectKey"] = cost{30, 0}
+		tests := []struct {
+			code  string
+			want  cost
+			found bool
+		}{
+			{"nilcheck", cost{1, 0}, true},
+			{"newobjectKey", cost{30, 0}, true},     // exact beats any prefix
+			{"newobjectValue", cost{20, 0.1}, true}, // newobject* is longer than new*
+			{"newarray", cost{5, 0}, true},          // only new* matches
+			{"newobject*", cost{20, 0.1}, true},     // a prefix key is also exact
+			{"unknownCode", cost{}, false},          // the default: nothing
+			{"ne", cost{}, false},                   // shorter than the prefix
+		}
+		for _, tc := range tests {
+			got, found := costFor(tc.code)
+			if got != tc.want || found != tc.found {
+				t.Errorf("costFor(%s) = %v, %v; want %v, %v", tc.code, got, found, tc.want, tc.found)
+			}
+		}
+	})
+}
+
+func TestDiagnosticBytes(t *testing.T) {
+	tests := []struct {
+		code, message string
+		want          int
+		ok            bool
+	}{
+		{"copy", "128 bytes", 128, true},
+		{"copy", "0 bytes", 0, true},
+		{"copy", "some bytes", 0, false},
+		{"newobject", "8P", 16, true},
+		{"newobject", "18", 16, true},      // padded to the alignment of the 8
+		{"newobjectKey", "[4]8", 32, true}, // an array
+		{"newobject", "1", 1, true},
+		{"newobject", "8z", 0, false}, // not a layout
+		{"nilcheck", "128 bytes", 0, false},
+		{"escape", "", 0, false},
+	}
+	for _, tc := range tests {
+		got, ok := diagnosticBytes(tc.code, tc.message)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("diagnosticBytes(%s, %q) = %d, %v; want %d, %v", tc.code, tc.message, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestRankByPayoff(t *testing.T) {
+	match := func(code string, cost float64, line int64) *Match {
+		return &Match{Code: code, Cost: cost, Position: Position{File: "foo.go", Line: line}, diagnostic: &lsp.Diagnostic{Code: code}}
+	}
+	nil1 := match("nilcheck", 1, 10)
+	esc := match("escape", 25, 11)
+	bounds := match("isInBounds", 2, 12)
+	tie := match("nilcheck", 1, 13)
+	free := match("inlineCall", 0, 14)
+	loopy := match("isInBounds", 2, 15)
+	loopy.LoopDepth, loopy.Induction = 1, "i"
+
+	r := &Report{Hotspots: []*Hotspot{
+		// nil1 is near two hot spots, so its percent is 2+2.
+		{Percent: 2, Diagnostics: []*Match{nil1, esc}},
+		{Percent: 2, Diagnostics: []*Match{nil1, bounds, tie, free}},
+		{Percent: 1, Diagnostics: []*Match{loopy}},
+		{Percent: 2, Diagnostics: []*Match{tie}},
+	}}
+	var got []string
+	for _, rk := range rankByPayoff(r) {
+		got = append(got, fmt.Sprintf("%s:%d=%g", rk.match.Code, rk.match.Position.Line, rk.payoff))
+	}
+	want := []string{
+		"escape:11=50",
+		"isInBounds:15=6", // 1% x 2, x3 for a loop indexed by its variable
+		// Ties keep the order of the hot spots: nil1 came first.
+		"nilcheck:10=4",
+		"isInBounds:12=4",
+		"nilcheck:13=4",
+		// inlineCall costs nothing, so is not ranked.
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("rankByPayoff = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// speedup returns the geometric mean of the ratios of the variant's ns/op to
+// the base's, over the benchmarks in both, or 0 if there are none.
+func speedup(base, variant map[string]float64) float64 {
+	sum, n := 0.0, 0
+	for name, b := range base {
+		if v, ok := variant[name]; ok && b > 0 && v > 0 {
+			sum += math.Log(v / b)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return math.Exp(sum / float64(n))
+}
+
+// reportExperiments prints, for each -experiment run in variants, the change
+// in ns/op of each benchmark from the normal run base, and, for each line
+// that is hot in either, its share of the normal run's time in both, the
+// variant's share being scaled by its overall ratio of ns/op.  The difference
+// is an estimate of the time saved at that line; for -B, it bounds what
+// removing that line's bounds checks could save.
+func reportExperiments(base artifacts, variants []artifacts) error {
+	basePI, byFile, err := readRun(base)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", base.profile, err)
+	}
+	if err := chooseThreshold(basePI); err != nil {
+		return err
+	}
+	if cutoff != "" {
+		fmt.Printf("Hot spots: %s\n", cutoff)
+	}
+	baseNs, names, err := readNsPerOp(base.bench)
+	if err != nil {
+		return err
+	}
+	baseShares := lineShares(basePI)
+
+	for i, v := range variants {
+		pi, err := readProfiles([]string{v.profile})
+		if err != nil {
+			return fmt.Errorf("reading %s: %v", v.profile, err)
+		}
+		remapProfile(pi, byFile)
+		ns, _, err := readNsPerOp(v.bench)
+		if err != nil {
+			return err
+		}
+		flags := "-gcflags=" + packages + experiments[i]
+
+		fmt.Printf("Experiment %s, compared with the normal build:\n", flags)
+		fmt.Printf("%-40s %14s %14s %8s\n", "benchmark", "normal ns/op", "ns/op", "delta")
+		for _, n := range names {
+			if _, ok := ns[n]; !ok {
+				fmt.Printf("%-40s %14.1f %14s\n", n, baseNs[n], "-")
+				continue
+			}
+			fmt.Printf("%-40s %14.1f %14.1f %+7.1f%%\n", n, baseNs[n], ns[n], 100*(ns[n]-baseNs[n])/baseNs[n])
+		}
+		ratio := speedup(baseNs, ns)
+		if ratio == 0 {
+			fmt.Printf("No benchmark results in common, so no estimates per line\n\n")
+			continue
+		}
+		fmt.Printf("Overall (geometric mean): %+.1f%%\n", 100*(ratio-1))
+
+		shares := lineShares(pi)
+		var lines []Position
+		for p, pct := range baseShares {
+			if pct >= threshold || ratio*shares[p] >= threshold {
+				lines = append(lines, p)
+			}
+		}
+		for p, pct := range shares {
+			if _, ok := baseShares[p]; !ok && ratio*pct >= threshold {
+				lines = append(lines, p)
+			}
+		}
+		saved := func(p Position) float64 { return baseShares[p] - ratio*shares[p] }
+		sort.Slice(lines, func(i, j int) bool {
+			si, sj := saved(lines[i]), saved(lines[j])
+			if si != sj {
+				return si > sj
+			}
+			if lines[i].File != lines[j].File {
+				return lines[i].File < lines[j].File
+			}
+			return lines[i].Line < lines[j].Line
+		})
+		fmt.Println("Hot lines, by share of the normal run's time saved:")
+		fmt.Printf("%7s %7s %7s  %s\n", "normal", "exp", "saved", "line")
+		for _, p := range lines {
+			fmt.Printf("%6.1f%% %6.1f%% %+6.1f  %s:%d\n", baseShares[p], ratio*shares[p], saved(p), shorten(p.File), p.Line)
+		}
+		if i+1 < len(variants) {
+			fmt.Println()
+		}
+	}
+	return nil
+}
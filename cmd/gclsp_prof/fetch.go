@@ -0,0 +1,63 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// fetchProfile fetches a profile from url, for example a net/http/pprof
+// endpoint such as http://localhost:6060/debug/pprof/profile?seconds=30
+// (or .../heap, or .../allocs), into a temporary file, and returns the
+// name of that file and a function to remove it.
+func fetchProfile(url string) (file string, cleanup func(), err error) {
+	if verbose > 0 {
+		fmt.Fprintf(os.Stderr, "Fetching profile from %s\n", url)
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// net/http/pprof explains errors in a line of text; other servers may say rather more.
+		msg := strings.TrimSpace(string(body))
+		if i := strings.IndexByte(msg, '\n'); i >= 0 {
+			msg = msg[:i] + "..."
+		}
+		return "", nil, fmt.Errorf("fetching %s: %s: %s", url, resp.Status, msg)
+	}
+	// Check that it is a profile, not, e.g., an HTML index page.
+	if _, err := profile.Parse(bytes.NewReader(body)); err != nil {
+		return "", nil, fmt.Errorf("fetching %s: not a profile: %v", url, err)
+	}
+
+	f, err := os.CreateTemp("", "GcLspProfFetch.*.pb.gz")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.Remove(f.Name()) }
+	if _, err := f.Write(body); err != nil {
+		f.Close()
+		cleanup()
+		return "", nil, err
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return f.Name(), cleanup, nil
+}
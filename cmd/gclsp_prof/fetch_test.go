@@ -0,0 +1,53 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime/pprof"
+	"testing"
+)
+
+func TestFetchProfile(t *testing.T) {
+	var heap bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&heap, 0); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/heap", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(heap.Bytes())
+	})
+	mux.HandleFunc("/debug/pprof/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>index</html>"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	file, cleanup, err := fetchProfile(ts.URL + "/debug/pprof/heap")
+	if err != nil {
+		t.Fatalf("fetchProfile: %v", err)
+	}
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, heap.Bytes()) {
+		t.Errorf("fetched %d bytes, want %d", len(got), heap.Len())
+	}
+	cleanup()
+	if _, err := os.Stat(file); err == nil {
+		t.Errorf("cleanup did not remove %s", file)
+	}
+
+	if _, _, err := fetchProfile(ts.URL + "/debug/pprof/"); err == nil {
+		t.Errorf("fetching a non-profile succeeded")
+	}
+	if _, _, err := fetchProfile(ts.URL + "/nowhere"); err == nil {
+		t.Errorf("fetching a missing page succeeded")
+	}
+}
@@ -0,0 +1,578 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"sort"
+	"strings"
+)
+
+// hint is a suggested source change for a missed optimization.  If insert is
+// not empty, the change can be made mechanically by inserting that line before
+// line before of file.
+type hint struct {
+	text   string
+	file   string
+	before int
+	insert string
+}
+
+// hintFor returns a suggested source change for the diagnostic of m,
+// based on its code and the syntax at its (innermost) position, or nil.
+func hintFor(m *Match) *hint {
+	switch m.Code {
+	case "isInBounds", "isSliceInBounds":
+		return boundsHint(m)
+	case "escape", "escapes":
+		return escapeHint(m)
+	case "copy":
+		return copyHint(m)
+	case "nilcheck":
+		return &hint{text: "if this pointer is used repeatedly (for example, in a loop), load the fields you need into locals once, or dereference it once before the loop"}
+	case "cannotInlineFunction":
+		return inlineFunctionHint(m.Message)
+	case "cannotInlineCall":
+		return inlineCallHint(m.Message)
+	}
+	return nil
+}
+
+func boundsHint(m *Match) *hint {
+	file, line, col := innermostPosition(m)
+	pf := parseSource(file)
+	if pf == nil {
+		return nil
+	}
+	path := pf.pathTo(line, col)
+	x, index := checkedIndex(path, m.Code)
+	if x == nil {
+		return nil
+	}
+	xs := pf.exprString(x)
+
+	loops := enclosingLoops(path)
+	if len(loops) == 0 {
+		if len(m.Inlines) > 0 {
+			opf := parseSource(m.Position.File)
+			if opf != nil {
+				if outer := enclosingLoops(opf.pathTo(int(m.Position.Line), 0)); len(outer) > 0 {
+					return &hint{text: fmt.Sprintf("the check of %s is in inlined code, called from a loop at line %d; "+
+						"checking the length of the underlying slice once, before that loop, may let the compiler remove it",
+						xs, opf.lineOf(outer[len(outer)-1]))}
+				}
+			}
+		}
+		return &hint{text: fmt.Sprintf("check the length of %s once, before its uses, with the largest index first (e.g. _ = %s[k] for the largest k)", xs, xs)}
+	}
+
+	loop := loops[len(loops)-1]
+	loopLine := pf.lineOf(loop)
+	// Re-slicing before the loop is a mechanical edit only if it cannot change
+	// what the loop or the rest of the function sees, nor panic where the loop
+	// would not: the index is exactly the loop variable, which runs from 0 up
+	// to the bound; every iteration indexes; the operand is a local slice; the
+	// loop assigns to neither it nor the bound and always runs to the end; and
+	// the operand is not used after the loop.  The bound is evaluated once
+	// more, so it must have no side effects.  Unless it is the length of
+	// something, it may be negative, or more than the length of the operand
+	// (growing it, where the loop would have panicked), so then the re-slice
+	// is guarded.
+	reslice := func(text, length string, exact bool, bound ast.Expr, guarded bool) *hint {
+		insert := fmt.Sprintf("%s = %s[:%s]", xs, xs, length)
+		if guarded {
+			insert = fmt.Sprintf("if 0 <= %s && %s <= len(%s) { %s }", length, length, xs, insert)
+		}
+		if !exact {
+			text += " (if every index used is below it)"
+		}
+		h := &hint{text: fmt.Sprintf("%s, so the compiler knows its length: %s", text, insert)}
+		id, ok := x.(*ast.Ident)
+		if !ok || !exact || !unconditional(path, loop) || assignedIn(loop, bound, id.Name) || exitsEarly(loop) ||
+			!sideEffectFree(bound) || usedAfter(enclosingFunc(path), loop, id.Name) || !localSlice(pf, id) {
+			return h
+		}
+		h.file, h.before, h.insert = file, loopLine, pf.indent(loopLine)+insert+" // bounds check hint"
+		return h
+	}
+
+	switch l := loop.(type) {
+	case *ast.ForStmt:
+		iv, bound, inclusive := inductionVariable(l)
+		if iv == "" || index == nil || !mentions(index, iv) {
+			break
+		}
+		length := pf.exprString(bound)
+		if inclusive {
+			length += "+1"
+		}
+		exact := isIdent(index, iv) && fromZero(l) && incremented(l, iv) && !assignedIn(l.Body, nil, iv)
+		return reslice(fmt.Sprintf("re-slice %s to the loop bound before the loop at line %d", xs, loopLine), length, exact, bound,
+			inclusive || !isLen(bound))
+
+	case *ast.RangeStmt:
+		key, ok := l.Key.(*ast.Ident)
+		if !ok || index == nil || !mentions(index, key.Name) {
+			break
+		}
+		over := pf.exprString(l.X)
+		if over == xs {
+			break
+		}
+		exact := isIdent(index, key.Name) && !assignedIn(l.Body, nil, key.Name) && indexesAll(pf, l.X)
+		return reslice(fmt.Sprintf("re-slice %s to the length of %s before the loop at line %d", xs, over, loopLine), "len("+over+")", exact, l.X, false)
+	}
+	return &hint{text: fmt.Sprintf("the index into %s is not derived from the loop variable, so the compiler cannot prove it in bounds; "+
+		"if it is known to be in range, check it (or mask it, for power-of-two lengths) once before the loop", xs)}
+}
+
+// isIdent reports whether e is the identifier name.
+func isIdent(e ast.Expr, name string) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && id.Name == name
+}
+
+// isLen reports whether e is a call of len.
+func isLen(e ast.Expr) bool {
+	call, ok := e.(*ast.CallExpr)
+	return ok && len(call.Args) == 1 && isIdent(call.Fun, "len")
+}
+
+// sideEffectFree reports whether evaluating e cannot have side effects or
+// panic: it is made of names, fields, constants, operators other than
+// receive, and calls of len and cap.
+func sideEffectFree(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.Ident, *ast.BasicLit:
+		return true
+	case *ast.ParenExpr:
+		return sideEffectFree(e.X)
+	case *ast.SelectorExpr:
+		return sideEffectFree(e.X)
+	case *ast.UnaryExpr:
+		return e.Op != token.ARROW && sideEffectFree(e.X)
+	case *ast.BinaryExpr:
+		// Division by zero panics.
+		return e.Op != token.QUO && e.Op != token.REM && sideEffectFree(e.X) && sideEffectFree(e.Y)
+	case *ast.CallExpr:
+		if id, ok := e.Fun.(*ast.Ident); ok && (id.Name == "len" || id.Name == "cap") && len(e.Args) == 1 {
+			return sideEffectFree(e.Args[0])
+		}
+	}
+	return false
+}
+
+// fromZero reports whether the init statement of l is i := 0 (or i = 0).
+func fromZero(l *ast.ForStmt) bool {
+	init, ok := l.Init.(*ast.AssignStmt)
+	if !ok || len(init.Rhs) != 1 {
+		return false
+	}
+	lit, ok := init.Rhs[0].(*ast.BasicLit)
+	return ok && lit.Value == "0"
+}
+
+// unconditional reports whether the innermost node of path runs on every
+// iteration of loop (which is in path) that reaches it, that is, whether it
+// is not within a conditional statement or expression, or a nested function,
+// inside the loop.
+func unconditional(path []ast.Node, loop ast.Node) bool {
+	inside := false
+	for _, n := range path {
+		if n == loop {
+			inside = true
+			continue
+		}
+		if !inside {
+			continue
+		}
+		switch n := n.(type) {
+		case *ast.IfStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt,
+			*ast.CaseClause, *ast.CommClause, *ast.ForStmt, *ast.RangeStmt, *ast.FuncLit,
+			*ast.GoStmt, *ast.DeferStmt:
+			return false
+		case *ast.BinaryExpr:
+			if n.Op == token.LAND || n.Op == token.LOR {
+				return false
+			}
+		}
+	}
+	return inside
+}
+
+// incremented reports whether the post statement of l is iv++.
+func incremented(l *ast.ForStmt, iv string) bool {
+	post, ok := l.Post.(*ast.IncDecStmt)
+	return ok && post.Tok == token.INC && isIdent(post.X, iv)
+}
+
+// assignedIn reports whether n assigns to (or takes the address of) the
+// variable name, or any variable mentioned in the expression also, if not nil.
+func assignedIn(n ast.Node, also ast.Expr, name string) bool {
+	names := map[string]bool{name: true}
+	if also != nil {
+		ast.Inspect(also, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				names[id.Name] = true
+			}
+			return true
+		})
+	}
+	assigned := false
+	target := func(e ast.Expr) {
+		if id, ok := e.(*ast.Ident); ok && names[id.Name] {
+			assigned = true
+		}
+	}
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			for _, lhs := range n.Lhs {
+				target(lhs)
+			}
+		case *ast.IncDecStmt:
+			target(n.X)
+		case *ast.UnaryExpr:
+			if n.Op == token.AND {
+				target(n.X)
+			}
+		case *ast.RangeStmt:
+			if n.Key != nil {
+				target(n.Key)
+			}
+			if n.Value != nil {
+				target(n.Value)
+			}
+		}
+		return !assigned
+	})
+	return assigned
+}
+
+// exitsEarly reports whether the body of loop may leave it other than by
+// its condition becoming false: by break, goto, return, or a labeled branch.
+func exitsEarly(loop ast.Stmt) bool {
+	var body *ast.BlockStmt
+	switch l := loop.(type) {
+	case *ast.ForStmt:
+		body = l.Body
+	case *ast.RangeStmt:
+		body = l.Body
+	}
+	exits := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ReturnStmt:
+			exits = true
+		case *ast.BranchStmt:
+			exits = exits || n.Tok == token.BREAK || n.Tok == token.GOTO || n.Label != nil
+		}
+		return !exits
+	})
+	return exits
+}
+
+// typedExpr returns e, from pf, as type checked (see typeCheck), with the
+// type information of its package, or nil.
+func typedExpr(pf *parsedFile, e ast.Expr) (ast.Expr, *types.Info) {
+	tp := typeCheck(pf.name)
+	if tp == nil {
+		return nil, nil
+	}
+	tf := tp.files[pf.name]
+	start, end := pf.fset.Position(e.Pos()).Offset, pf.fset.Position(e.End()).Offset
+	var found ast.Expr
+	ast.Inspect(tf.file, func(n ast.Node) bool {
+		if found != nil || n == nil {
+			return false
+		}
+		if x, ok := n.(ast.Expr); ok && tf.fset.Position(x.Pos()).Offset == start && tf.fset.Position(x.End()).Offset == end &&
+			fmt.Sprintf("%T", x) == fmt.Sprintf("%T", e) {
+			found = x
+			return false
+		}
+		return tf.fset.Position(n.Pos()).Offset <= start && end <= tf.fset.Position(n.End()).Offset
+	})
+	if found == nil {
+		return nil, nil
+	}
+	return found, tp.info
+}
+
+// localSlice reports whether id, in pf, is a variable of slice type
+// declared in a function.
+func localSlice(pf *parsedFile, id *ast.Ident) bool {
+	e, info := typedExpr(pf, id)
+	if e == nil {
+		return false
+	}
+	v, ok := info.Uses[e.(*ast.Ident)].(*types.Var)
+	if !ok || v.Pkg() == nil || v.Parent() == v.Pkg().Scope() || v.IsField() {
+		return false
+	}
+	_, ok = v.Type().Underlying().(*types.Slice)
+	return ok
+}
+
+// indexesAll reports whether ranging over e, in pf, yields every index from
+// 0 to len(e)-1, that is, whether e is a slice, array or pointer to array
+// (not a string, whose indexes are those of its runes).
+func indexesAll(pf *parsedFile, e ast.Expr) bool {
+	te, info := typedExpr(pf, e)
+	if te == nil {
+		return false
+	}
+	t := info.TypeOf(te)
+	if t == nil {
+		return false
+	}
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		t = p.Elem()
+		if _, ok := t.Underlying().(*types.Array); !ok {
+			return false
+		}
+	}
+	switch t.Underlying().(type) {
+	case *types.Slice, *types.Array:
+		return true
+	}
+	return false
+}
+
+// checkedIndex returns the innermost indexed (or sliced) expression in path
+// that a bounds check with code could apply to, and the index (or high
+// bound), which may be nil.
+func checkedIndex(path []ast.Node, code string) (x, index ast.Expr) {
+	for i := len(path) - 1; i >= 0; i-- {
+		switch n := path[i].(type) {
+		case *ast.IndexExpr:
+			if code == "isInBounds" {
+				return n.X, n.Index
+			}
+		case *ast.SliceExpr:
+			if code == "isSliceInBounds" {
+				return n.X, n.High
+			}
+		}
+	}
+	return nil, nil
+}
+
+// inductionVariable returns the name of the variable of a loop of the form
+// for i := a; i < n; i++ (or i <= n), and the bound n, or "" if it is not of that form.
+func inductionVariable(l *ast.ForStmt) (name string, bound ast.Expr, inclusive bool) {
+	init, ok := l.Init.(*ast.AssignStmt)
+	if !ok || len(init.Lhs) != 1 {
+		return "", nil, false
+	}
+	iv, ok := init.Lhs[0].(*ast.Ident)
+	if !ok {
+		return "", nil, false
+	}
+	cond, ok := l.Cond.(*ast.BinaryExpr)
+	if !ok {
+		return "", nil, false
+	}
+	if x, ok := cond.X.(*ast.Ident); !ok || x.Name != iv.Name {
+		return "", nil, false
+	}
+	switch cond.Op {
+	case token.LSS:
+		return iv.Name, cond.Y, false
+	case token.LEQ:
+		return iv.Name, cond.Y, true
+	}
+	return "", nil, false
+}
+
+// usedAfter reports whether name is mentioned in fn after the end of loop.
+func usedAfter(fn, loop ast.Node, name string) bool {
+	if fn == nil {
+		return true
+	}
+	used := false
+	ast.Inspect(fn, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Pos() >= loop.End() && id.Name == name {
+			used = true
+		}
+		return !used
+	})
+	return used
+}
+
+// mentions reports whether e refers to the identifier name.
+func mentions(e ast.Node, name string) bool {
+	found := false
+	ast.Inspect(e, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == name {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+func escapeHint(m *Match) *hint {
+	what := strings.TrimSuffix(m.Message, " escapes to heap")
+	if what == "" {
+		what = "this value"
+	}
+	file, line, col := innermostPosition(m)
+	pf := parseSource(file)
+	if pf == nil {
+		return nil
+	}
+	path := pf.pathTo(line, col)
+	var inReturn, addrOf bool
+	var call *ast.CallExpr
+	for _, n := range path {
+		switch n := n.(type) {
+		case *ast.ReturnStmt:
+			inReturn = true
+		case *ast.UnaryExpr:
+			addrOf = addrOf || n.Op == token.AND
+		case *ast.CallExpr:
+			call = n
+		}
+	}
+	if !addrOf {
+		// The position of "&x" may be at x, not the &.
+		for _, u := range nodesOnLine[*ast.UnaryExpr](pf, line) {
+			addrOf = addrOf || u.Op == token.AND
+		}
+	}
+	switch {
+	case inReturn && addrOf:
+		return &hint{text: fmt.Sprintf("%s is returned by pointer; return a value instead, and let callers take its address only if they must", what)}
+	case call != nil:
+		return &hint{text: fmt.Sprintf("%s escapes through the call to %s; if the callee retains it or it is converted to an interface, "+
+			"pass a copy, a smaller value, or restructure so the callee does not retain it", what, pf.exprString(call.Fun))}
+	case len(enclosingLoops(path)) > 0:
+		return &hint{text: fmt.Sprintf("%s is heap allocated on each iteration; allocate it once before the loop and reuse it", what)}
+	}
+	return &hint{text: fmt.Sprintf("%s outlives its function (-e explains why); keeping it from being stored in heap objects, "+
+		"returned by pointer, captured by escaping closures, or converted to an interface lets it stay on the stack", what)}
+}
+
+// copyContext classifies the syntax at a "copy" diagnostic as
+// "range", "argument", "return", "assignment" or "other".
+func copyContext(pf *parsedFile, line, col int) string {
+	path := pf.pathTo(line, col)
+	for i := len(path) - 1; i >= 0; i-- {
+		switch n := path[i].(type) {
+		case *ast.RangeStmt:
+			if n.Value != nil && pf.lineOf(n) == line {
+				return "range"
+			}
+		case *ast.CallExpr:
+			return "argument"
+		case *ast.ReturnStmt:
+			return "return"
+		case *ast.AssignStmt, *ast.ValueSpec:
+			return "assignment"
+		case *ast.BlockStmt:
+			return "other"
+		}
+	}
+	for _, r := range nodesOnLine[*ast.RangeStmt](pf, line) {
+		if r.Value != nil {
+			return "range"
+		}
+	}
+	return "other"
+}
+
+func copyHint(m *Match) *hint {
+	file, line, col := innermostPosition(m)
+	pf := parseSource(file)
+	if pf == nil {
+		return nil
+	}
+	size := strings.TrimSuffix(m.Message, " bytes")
+	switch copyContext(pf, line, col) {
+	case "range":
+		return &hint{text: fmt.Sprintf("the range loop copies each %s-byte element; iterate by index instead, for i := range s { v := &s[i] ... }", size)}
+	case "argument":
+		return &hint{text: fmt.Sprintf("a %s-byte value is passed by value; pass a pointer to it instead", size)}
+	case "return":
+		return &hint{text: fmt.Sprintf("a %s-byte value is returned by value; consider filling in a caller-supplied pointer instead", size)}
+	case "assignment":
+		return &hint{text: fmt.Sprintf("the assignment copies %s bytes; use a pointer to the original instead, if it is not modified", size)}
+	}
+	return &hint{text: fmt.Sprintf("%s bytes are copied here; passing or storing a pointer instead would avoid the copy", size)}
+}
+
+func inlineFunctionHint(reason string) *hint {
+	var cost, budget int
+	if _, err := fmt.Sscanf(reason, "function too complex: cost %d exceeds budget %d", &cost, &budget); err == nil {
+		return &hint{text: fmt.Sprintf("cost %d exceeds the inlining budget %d by %d; split rarely executed paths (errors, slow paths) "+
+			"into a separate function so the common path fits", cost, budget, cost-budget)}
+	}
+	if strings.Contains(reason, "go:noinline") {
+		return &hint{text: "remove the //go:noinline directive, if it is not needed"}
+	}
+	return &hint{text: fmt.Sprintf("not inlinable because %s; move that into a separate function so the rest can be inlined", reason)}
+}
+
+func inlineCallHint(reason string) *hint {
+	var cost, max int
+	var callee string
+	if _, err := fmt.Sscanf(reason, "cost %d of %s exceeds max caller cost %d", &cost, &callee, &max); err == nil {
+		return &hint{text: fmt.Sprintf("%s (cost %d) is not inlined into this large caller (limit %d); make %s smaller, "+
+			"or move this call into a smaller function", callee, cost, max, callee)}
+	}
+	return nil
+}
+
+// writeHintDiff writes the mechanical hints among hs as a unified diff,
+// relative to the current directory where possible.
+func writeHintDiff(hs []*hint, file string) error {
+	byFile := make(map[string][]*hint)
+	var files []string
+	seen := make(map[hint]bool)
+	for _, h := range hs {
+		if h == nil || h.insert == "" || seen[*h] {
+			continue
+		}
+		seen[*h] = true
+		if byFile[h.file] == nil {
+			files = append(files, h.file)
+		}
+		byFile[h.file] = append(byFile[h.file], h)
+	}
+	sort.Strings(files)
+
+	var sb strings.Builder
+	for _, f := range files {
+		pf := parseSource(f)
+		name := relative(f)
+		fmt.Fprintf(&sb, "--- %s\n+++ %s\n", name, name)
+		edits := byFile[f]
+		sort.SliceStable(edits, func(i, j int) bool { return edits[i].before < edits[j].before })
+		added := 0
+		for i := 0; i < len(edits); {
+			// All the insertions before one line, followed by that line as context.
+			before := edits[i].before
+			j := i
+			for j < len(edits) && edits[j].before == before {
+				j++
+			}
+			fmt.Fprintf(&sb, "@@ -%d,1 +%d,%d @@\n", before, before+added, j-i+1)
+			for _, h := range edits[i:j] {
+				fmt.Fprintf(&sb, "+%s\n", h.insert)
+			}
+			fmt.Fprintf(&sb, " %s\n", pf.line(before))
+			added += j - i
+			i = j
+		}
+	}
+	return os.WriteFile(file, []byte(sb.String()), 0666)
+}
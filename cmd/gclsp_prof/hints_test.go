@@ -0,0 +1,200 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+)
+
+const hintsSource = `package p
+
+var global []int
+
+func exact(xs []int, n int) (s int) {
+	for i := 0; i < n; i++ {
+		s += xs[i] // exact
+	}
+	return
+}
+
+func rangeExact(xs, ys []int) (s int) {
+	for i := range ys {
+		s += xs[i] // rangeExact
+	}
+	return
+}
+
+func offset(xs []int, n int) (s int) {
+	for i := 0; i < n; i++ {
+		s += xs[i+1] // offset
+	}
+	return
+}
+
+func indirect(xs, idx []int) (s int) {
+	for i := range idx {
+		s += xs[idx[i]] // indirect
+	}
+	return
+}
+
+func array(xs [8]int, n int) (s int) {
+	for i := 0; i < n; i++ {
+		s += xs[i] // array
+	}
+	return
+}
+
+func assigned(xs []int, n int) (s int) {
+	for i := 0; i < n; i++ {
+		s += xs[i] // assigned
+		xs = xs[1:]
+	}
+	return
+}
+
+func bound(xs []int, n int) (s int) {
+	for i := 0; i < n; i++ {
+		s += xs[i] // bound
+		n--
+	}
+	return
+}
+
+func conditional(xs []int, n int) (s int) {
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			s += xs[i] // conditional
+		}
+	}
+	return
+}
+
+func early(xs []int, n int) (s int) {
+	for i := 0; i < n; i++ {
+		if s > 100 {
+			break
+		}
+		s += xs[i] // early
+	}
+	return
+}
+
+func usedLater(xs []int, n int) (s int) {
+	for i := 0; i < n; i++ {
+		s += xs[i] // usedLater
+	}
+	return s + len(xs)
+}
+
+func pkgLevel(n int) (s int) {
+	for i := 0; i < n; i++ {
+		s += global[i] // pkgLevel
+	}
+	return
+}
+
+func str(xs []int, ys string) (s int) {
+	for i := range ys {
+		s += xs[i] // str
+	}
+	return
+}
+
+func lenBound(xs, ys []int) (s int) {
+	for i := 0; i < len(ys); i++ {
+		s += xs[i] // lenBound
+	}
+	return
+}
+
+func call(xs []int, f func() int) (s int) {
+	for i := 0; i < f(); i++ {
+		s += xs[i] // call
+	}
+	return
+}
+`
+
+func TestBoundsHint(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "p.go")
+	if err := os.WriteFile(file, []byte(hintsSource), 0666); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(hintsSource, "\n")
+	match := func(marker string) *Match {
+		for i, l := range lines {
+			if strings.HasSuffix(l, "// "+marker) {
+				d := &lsp.Diagnostic{Code: "isInBounds"}
+				d.Range.Start.Line = uint(i + 1)
+				d.Range.Start.Character = uint(strings.Index(l, "[") + 1)
+				return &Match{Code: "isInBounds", Position: Position{File: file, Line: int64(i + 1)}, diagnostic: d}
+			}
+		}
+		t.Fatalf("no line marked %s", marker)
+		return nil
+	}
+
+	tests := []struct {
+		marker string
+		insert string // "" for text only
+		text   string
+	}{
+		// n may be negative, or more than the length of xs.
+		{"exact", "\tif 0 <= n && n <= len(xs) { xs = xs[:n] } // bounds check hint", "re-slice xs to the loop bound"},
+		{"rangeExact", "\txs = xs[:len(ys)] // bounds check hint", "re-slice xs to the length of ys"},
+		{"offset", "", "if every index used is below it"},
+		{"indirect", "", "if every index used is below it"},
+		{"array", "", "re-slice xs"},
+		{"assigned", "", "re-slice xs"},
+		{"bound", "", "re-slice xs"},
+		{"conditional", "", "re-slice xs"},
+		{"early", "", "re-slice xs"},
+		{"usedLater", "", "re-slice xs"},
+		{"pkgLevel", "", "re-slice global"},
+		{"str", "", "re-slice xs"},
+		{"lenBound", "\txs = xs[:len(ys)] // bounds check hint", "re-slice xs to the loop bound"},
+		// f would be called once more.
+		{"call", "", "re-slice xs"},
+	}
+	var hs []*hint
+	for _, tc := range tests {
+		h := hintFor(match(tc.marker))
+		if h == nil {
+			t.Errorf("%s: no hint", tc.marker)
+			continue
+		}
+		if h.insert != tc.insert {
+			t.Errorf("%s: insert %q, want %q", tc.marker, h.insert, tc.insert)
+		}
+		if !strings.Contains(h.text, tc.text) {
+			t.Errorf("%s: hint %q does not contain %q", tc.marker, h.text, tc.text)
+		}
+		hs = append(hs, h)
+	}
+
+	out := filepath.Join(dir, "hints.diff")
+	if err := writeHintDiff(hs, out); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := relative(file)
+	want := "--- " + name + "\n+++ " + name + "\n" +
+		"@@ -6,1 +6,2 @@\n+\tif 0 <= n && n <= len(xs) { xs = xs[:n] } // bounds check hint\n \tfor i := 0; i < n; i++ {\n" +
+		"@@ -13,1 +14,2 @@\n+\txs = xs[:len(ys)] // bounds check hint\n \tfor i := range ys {\n" +
+		"@@ -97,1 +99,2 @@\n+\txs = xs[:len(ys)] // bounds check hint\n \tfor i := 0; i < len(ys); i++ {\n"
+	if got := string(b); got != want {
+		t.Errorf("writeHintDiff:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"os"
+	"sort"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+	"github.com/dr2chase/gc-lsp-tools/prof"
+)
+
+// htmlFile is one source file of the HTML report.
+type htmlFile struct {
+	Name    string // abbreviated
+	Percent float64
+	Lines   []*htmlLine
+	Missing bool // the source could not be read
+}
+
+// htmlLine is one line of a source file in the HTML report.
+type htmlLine struct {
+	Number      int64
+	Text        string
+	Percent     float64
+	Shade       float64 // 0 to 1, proportional to Percent, for the background color
+	Diagnostics []*Match
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"short": shorten,
+	"pct":   func(f float64) string { return fmt.Sprintf("%.1f%%", f) },
+	"shade": func(f float64) template.CSS {
+		return template.CSS(fmt.Sprintf("background-color: rgba(255, 64, 0, %.3f)", f))
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gclsp_prof report</title>
+<style>
+body { font-family: sans-serif; }
+table.src { border-collapse: collapse; font-family: monospace; white-space: pre; }
+table.src td { padding: 0 0.5em; vertical-align: top; }
+td.num, td.pct { text-align: right; color: #666; }
+div.diag { font-family: sans-serif; white-space: normal; margin: 0.2em 0 0.2em 2em; padding: 0.2em 0.5em; border-left: 3px solid #36c; background: #eef; }
+div.diag summary { cursor: pointer; }
+div.diag ul { margin: 0.2em 0; }
+</style>
+</head>
+<body>
+<h1>Missed optimizations at hot spots</h1>
+<p>Threshold {{pct .Report.Threshold}}{{if .Report.Cutoff}} ({{.Report.Cutoff}}){{end}}, diagnostics from {{.Report.Before}} line(s) before to {{.Report.After}} line(s) after each hot spot.
+Lines are shaded by their share of samples.</p>
+<ul>
+{{range $i, $f := .Files}}<li><a href="#f{{$i}}">{{$f.Name}}</a> ({{pct $f.Percent}})</li>
+{{end}}</ul>
+{{range $i, $f := .Files}}
+<h2 id="f{{$i}}">{{$f.Name}}</h2>
+{{if $f.Missing}}<p>Source not available; hot lines and diagnostics:</p>
+<ul>
+{{range $f.Lines}}<li>line {{.Number}}{{if .Percent}}, {{pct .Percent}}{{end}}{{template "diags" .Diagnostics}}</li>
+{{end}}</ul>
+{{else}}<table class="src">
+{{range $f.Lines}}<tr style="{{shade .Shade}}"><td class="num">{{.Number}}</td><td class="pct">{{if .Percent}}{{pct .Percent}}{{end}}</td><td>{{.Text}}{{template "diags" .Diagnostics}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}
+</body>
+</html>
+{{define "diags"}}{{range .}}
+<div class="diag"><details><summary><b>{{.Code}}</b>{{if .Message}}: {{.Message}}{{end}}{{if .Inlines}} (inlined){{end}}</summary>
+{{if .Inlines}}<div>Inlined at:</div><ul>{{range .Inlines}}<li>{{short .File}}:{{.Line}}{{if .EndLine}}-{{.EndLine}}{{end}}</li>{{end}}</ul>{{end}}
+{{if .Explanations}}<div>Explanation:</div><ul>{{range .Explanations}}<li>{{short .File}}:{{.Line}}, {{.Message}}{{range .Inlines}}<br>(inline) {{short .File}}:{{.Line}}{{end}}</li>{{end}}</ul>{{end}}
+</details></div>{{end}}{{end}}`))
+
+// writeHTML writes a self-contained HTML page to file, listing each source
+// file containing a hot spot of r, with each line shaded by its share of the
+// samples in pi, and the diagnostics of r shown at their lines.
+func writeHTML(r *Report, pi []*prof.ProfileItem, file string) error {
+	// Flat percentages by file and line
+	byLine := make(map[string]map[int64]float64)
+	for _, p := range pi {
+		fl := p.FileLine[0]
+		m := byLine[fl.SourceFile]
+		if m == nil {
+			m = make(map[int64]float64)
+			byLine[fl.SourceFile] = m
+		}
+		m[fl.Line] += p.FlatPercent
+	}
+
+	// Diagnostics by file and line, each only once even if near several hot spots
+	diags := make(map[string]map[int64][]*Match)
+	seen := make(map[*lsp.Diagnostic]bool)
+	var names []string
+	for _, h := range r.Hotspots {
+		if diags[h.Position.File] == nil {
+			diags[h.Position.File] = make(map[int64][]*Match)
+			names = append(names, h.Position.File)
+		}
+		for _, m := range h.Diagnostics {
+			if seen[m.diagnostic] {
+				continue
+			}
+			seen[m.diagnostic] = true
+			diags[h.Position.File][m.Position.Line] = append(diags[h.Position.File][m.Position.Line], m)
+		}
+	}
+
+	var files []*htmlFile
+	maxPercent := 0.0
+	for _, name := range names {
+		f := &htmlFile{Name: shorten(name)}
+		for _, pct := range byLine[name] {
+			f.Percent += pct
+			if pct > maxPercent {
+				maxPercent = pct
+			}
+		}
+		src, err := os.Open(name)
+		if err != nil {
+			// List just the hot lines and those with diagnostics, without text.
+			f.Missing = true
+			var numbers []int64
+			for n, pct := range byLine[name] {
+				if pct >= threshold {
+					numbers = append(numbers, n)
+				}
+			}
+			for n := range diags[name] {
+				if byLine[name][n] < threshold {
+					numbers = append(numbers, n)
+				}
+			}
+			sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+			for _, n := range numbers {
+				f.Lines = append(f.Lines, &htmlLine{Number: n, Percent: byLine[name][n], Diagnostics: diags[name][n]})
+			}
+			files = append(files, f)
+			continue
+		}
+		scanner := bufio.NewScanner(src)
+		scanner.Buffer(nil, 1<<20)
+		for n := int64(1); scanner.Scan(); n++ {
+			f.Lines = append(f.Lines, &htmlLine{Number: n, Text: scanner.Text(), Percent: byLine[name][n], Diagnostics: diags[name][n]})
+		}
+		src.Close()
+		files = append(files, f)
+	}
+	for _, f := range files {
+		for _, l := range f.Lines {
+			if maxPercent > 0 {
+				l.Shade = l.Percent / maxPercent
+			}
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Percent > files[j].Percent })
+
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	err = htmlTemplate.Execute(out, struct {
+		Report *Report
+		Files  []*htmlFile
+	}{r, files})
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,51 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+	"github.com/dr2chase/gc-lsp-tools/prof"
+)
+
+func TestWriteHTMLMissingSource(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "gone.go")
+	pi := []*prof.ProfileItem{
+		{FlatPercent: 40, FileLine: []prof.FileLine{{SourceFile: missing, Line: 12}}},
+		{FlatPercent: 0.1, FileLine: []prof.FileLine{{SourceFile: missing, Line: 30}}},
+	}
+	r := &Report{Threshold: threshold, Hotspots: []*Hotspot{{
+		Percent:  40,
+		Position: Position{File: missing, Line: 12},
+		Diagnostics: []*Match{
+			{Code: "isInBounds", Position: Position{File: missing, Line: 12}, diagnostic: &lsp.Diagnostic{}},
+			{Code: "nilcheck", Position: Position{File: missing, Line: 11}, diagnostic: &lsp.Diagnostic{}},
+		},
+	}}}
+	out := filepath.Join(t.TempDir(), "report.html")
+	if err := writeHTML(r, pi, out); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(b)
+	for _, want := range []string{"Source not available", "<li>line 11", "<li>line 12, 40.0%", "<b>isInBounds</b>", "<b>nilcheck</b>"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("HTML report does not contain %q", want)
+		}
+	}
+	if strings.Contains(page, "line 30") {
+		t.Errorf("HTML report lists line 30, which is not hot and has no diagnostics")
+	}
+	if strings.Index(page, "<li>line 11") > strings.Index(page, "<li>line 12") {
+		t.Errorf("HTML report lists lines out of order")
+	}
+}
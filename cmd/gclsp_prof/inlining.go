@@ -0,0 +1,301 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"sort"
+	"strings"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+	"github.com/dr2chase/gc-lsp-tools/prof"
+)
+
+// Approximations of the inliner's costs, see cmd/compile/internal/inline.
+const (
+	inlineExtraCallCost = 57 // for a call that is not itself inlined
+)
+
+// builtins are the predeclared functions, whose calls cost no more than other expressions.
+var builtins = map[string]bool{
+	"append": true, "cap": true, "clear": true, "close": true, "complex": true, "copy": true, "delete": true,
+	"imag": true, "len": true, "make": true, "max": true, "min": true, "new": true, "panic": true,
+	"print": true, "println": true, "real": true, "recover": true,
+}
+
+type fileLine struct {
+	file string
+	line int64
+}
+
+// inlineDiagnostics returns the canInlineFunction and cannotInlineFunction
+// diagnostics in byFile, indexed by the file and line of the function.
+func inlineDiagnostics(byFile map[string]*lsp.CompilerDiagnostics) map[fileLine]*lsp.Diagnostic {
+	result := make(map[fileLine]*lsp.Diagnostic)
+	for file, cd := range byFile {
+		for _, d := range cd.Diagnostics {
+			if d.Code == "canInlineFunction" || d.Code == "cannotInlineFunction" {
+				result[fileLine{file, int64(d.Range.Start.Line)}] = d
+			}
+		}
+	}
+	return result
+}
+
+// tooComplex returns the cost and budget of a function that is too complex to inline.
+func tooComplex(d *lsp.Diagnostic) (cost, budget int, ok bool) {
+	if d == nil || d.Code != "cannotInlineFunction" {
+		return 0, 0, false
+	}
+	_, err := fmt.Sscanf(d.Message, "function too complex: cost %d exceeds budget %d", &cost, &budget)
+	return cost, budget, err == nil
+}
+
+// inlineCost returns the cost of an inlinable function.
+func inlineCost(d *lsp.Diagnostic) (cost int, ok bool) {
+	if d == nil || d.Code != "canInlineFunction" {
+		return 0, false
+	}
+	_, err := fmt.Sscanf(d.Message, "cost: %d", &cost)
+	return cost, err == nil
+}
+
+// stmtCost is the estimated inlining cost of one statement, not counting
+// the statements nested within it.
+type stmtCost struct {
+	line int
+	text string
+	cost int
+}
+
+// funcAt returns the function declaration or literal starting on line of pf, or nil.
+func funcAt(pf *parsedFile, line int) ast.Node {
+	if fns := nodesOnLine[*ast.FuncDecl](pf, line); len(fns) > 0 {
+		return fns[0]
+	}
+	if fns := nodesOnLine[*ast.FuncLit](pf, line); len(fns) > 0 {
+		return fns[0]
+	}
+	return nil
+}
+
+// calledFunc returns the function or concrete method called by call, in pf,
+// if the package type checks (see typeCheck), or nil.
+func calledFunc(pf *parsedFile, call *ast.CallExpr) *types.Func {
+	var id *ast.Ident
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		id = fun
+	case *ast.SelectorExpr:
+		id = fun.Sel
+	case *ast.IndexExpr: // generic, F[T](...)
+		return calledFunc(pf, &ast.CallExpr{Fun: fun.X})
+	case *ast.IndexListExpr:
+		return calledFunc(pf, &ast.CallExpr{Fun: fun.X})
+	default:
+		return nil
+	}
+	te, info := typedExpr(pf, id)
+	if te == nil {
+		return nil
+	}
+	fn, ok := info.Uses[te.(*ast.Ident)].(*types.Func)
+	if !ok {
+		return nil
+	}
+	if recv := fn.Type().(*types.Signature).Recv(); recv != nil && types.IsInterface(recv.Type()) {
+		return nil // not known until run time
+	}
+	return fn
+}
+
+// calleeDecl returns the file and line of the declaration of the function
+// or concrete method called by call, in pf, if it and its source position
+// are known (see calledFunc).
+func calleeDecl(pf *parsedFile, call *ast.CallExpr) (fileLine, bool) {
+	fn := calledFunc(pf, call)
+	if fn == nil || !fn.Pos().IsValid() {
+		return fileLine{}, false
+	}
+	pos := typeCheck(pf.name).files[pf.name].fset.Position(fn.Pos())
+	file, _ := cachedLocalPath(pos.Filename)
+	return fileLine{file, int64(pos.Line)}, true
+}
+
+// statementCosts estimates the inlining cost of each statement of the
+// function fn in pf, in decreasing order of cost.  Like the inliner,
+// it counts syntax nodes, plus the cost of calls: that of the callee
+// if it is an inlinable function or method, and otherwise a fixed extra
+// cost.  Callees are found by type checking fn's package; if that fails,
+// only functions declared in pf are found.
+func statementCosts(pf *parsedFile, fn ast.Node, inl map[fileLine]*lsp.Diagnostic) []stmtCost {
+	var body *ast.BlockStmt
+	switch fn := fn.(type) {
+	case *ast.FuncDecl:
+		body = fn.Body
+	case *ast.FuncLit:
+		body = fn.Body
+	}
+	if body == nil {
+		return nil
+	}
+
+	decls := make(map[string]int) // function name to line, in this file
+	for _, d := range pf.file.Decls {
+		if fd, ok := d.(*ast.FuncDecl); ok && fd.Recv == nil {
+			decls[fd.Name.Name] = pf.lineOf(fd)
+		}
+	}
+	callCost := func(call *ast.CallExpr) int {
+		if id, ok := call.Fun.(*ast.Ident); ok && builtins[id.Name] {
+			return 0
+		}
+		k, ok := calleeDecl(pf, call)
+		if id, isIdent := call.Fun.(*ast.Ident); !ok && isIdent {
+			k, ok = fileLine{pf.name, int64(decls[id.Name])}, decls[id.Name] != 0
+		}
+		if ok {
+			if cost, ok := inlineCost(inl[k]); ok {
+				return cost
+			}
+		}
+		return inlineExtraCallCost
+	}
+
+	var costs []stmtCost
+	var visit func(s ast.Stmt)
+	visit = func(s ast.Stmt) {
+		sc := stmtCost{line: pf.lineOf(s), text: strings.TrimSpace(pf.line(pf.lineOf(s)))}
+		var nested []ast.Stmt
+		ast.Inspect(s, func(n ast.Node) bool {
+			if n == nil {
+				return false
+			}
+			if b, ok := n.(*ast.BlockStmt); ok && n != ast.Node(s) {
+				nested = append(nested, b.List...)
+				return false
+			}
+			if is, ok := n.(*ast.IfStmt); ok && n != ast.Node(s) { // else if
+				nested = append(nested, is)
+				return false
+			}
+			if cc, ok := n.(*ast.CaseClause); ok {
+				nested = append(nested, cc.Body...)
+				sc.cost++
+				return false
+			}
+			if cc, ok := n.(*ast.CommClause); ok {
+				nested = append(nested, cc.Body...)
+				sc.cost++
+				return false
+			}
+			sc.cost++
+			if call, ok := n.(*ast.CallExpr); ok {
+				sc.cost += callCost(call)
+			}
+			return true
+		})
+		costs = append(costs, sc)
+		for _, n := range nested {
+			visit(n)
+		}
+	}
+	for _, s := range body.List {
+		visit(s)
+	}
+	sort.SliceStable(costs, func(i, j int) bool { return costs[i].cost > costs[j].cost })
+	return costs
+}
+
+// reportInlining prints the hot functions (by cumulative samples in
+// profiles) that narrowly missed inlining, with the statements that
+// contribute most to their cost, and the hot calls that were not inlined,
+// from code with diagnostics, with the reason.
+func reportInlining(profiles []string, byFile map[string]*lsp.CompilerDiagnostics) error {
+	opts := prof.Options{Binary: binary, SampleType: sampleType, Verbose: int(verbose)}
+	funcs, calls, err := prof.Cumulative(profiles, opts)
+	if err != nil {
+		return err
+	}
+	for _, f := range funcs {
+		f.SourceFile, _ = cachedLocalPath(f.SourceFile)
+	}
+	for _, c := range calls {
+		c.Caller.SourceFile, _ = cachedLocalPath(c.Caller.SourceFile)
+	}
+	inl := inlineDiagnostics(byFile)
+	tab := "        "
+
+	if cutoff != "" {
+		fmt.Printf("Hot spots: %s\n", cutoff)
+	}
+	fmt.Printf("Hot functions that narrowly missed inlining (cost at most %.0f%% of the budget), by cumulative samples:\n", 100*nearMiss)
+	found := false
+	byName := make(map[string]*prof.FuncItem)
+	for _, f := range funcs {
+		byName[f.Name] = f
+		if f.CumPercent < threshold {
+			continue
+		}
+		d := inl[fileLine{f.SourceFile, f.StartLine}]
+		cost, budget, ok := tooComplex(d)
+		if !ok || float64(cost) > nearMiss*float64(budget) {
+			continue
+		}
+		found = true
+		fmt.Printf("%5.1f%%, %s %s:%d, cost %d exceeds budget %d by %d\n", f.CumPercent, f.Name, shorten(f.SourceFile), f.StartLine, cost, budget, cost-budget)
+		pf := parseSource(f.SourceFile)
+		if pf == nil {
+			continue
+		}
+		fn := funcAt(pf, int(f.StartLine))
+		if fn == nil {
+			continue
+		}
+		for i, sc := range statementCosts(pf, fn, inl) {
+			if i == 5 || sc.cost == 0 {
+				break
+			}
+			fmt.Printf("%8s~%-4d line %d: %s\n", tab, sc.cost, sc.line, sc.text)
+		}
+	}
+	if !found {
+		fmt.Printf("%8s(none)\n", "")
+	}
+
+	fmt.Printf("Hot calls that were not inlined, by cumulative samples:\n")
+	found = false
+	for _, c := range calls {
+		if c.Inlined || c.CumPercent < threshold {
+			continue
+		}
+		callee := byName[c.Callee]
+		cd := byFile[c.Caller.SourceFile]
+		if callee == nil || cd == nil {
+			continue
+		}
+		d := inl[fileLine{callee.SourceFile, callee.StartLine}]
+		if d == nil {
+			continue // not compiled with diagnostics, e.g., the runtime
+		}
+		reason := d.Message
+		if cost, ok := inlineCost(d); ok {
+			reason = fmt.Sprintf("inlinable (cost %d), but not inlined here", cost)
+		}
+		for _, d := range cd.Diagnostics {
+			if d.Code == "cannotInlineCall" && int64(d.Range.Start.Line) == c.Caller.Line {
+				reason = d.Message
+			}
+		}
+		found = true
+		fmt.Printf("%5.1f%%, %s:%d) %s calls %s: %s\n", c.CumPercent, shorten(c.Caller.SourceFile), c.Caller.Line, c.CallerName, c.Callee, reason)
+	}
+	if !found {
+		fmt.Printf("%8s(none)\n", "")
+	}
+	return nil
+}
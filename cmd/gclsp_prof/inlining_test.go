@@ -0,0 +1,74 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+)
+
+func TestStatementCosts(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.go")
+	b := filepath.Join(dir, "b.go")
+	files := map[string]string{
+		a: `package p
+
+type I interface{ M() int }
+
+func caller(t *T, i I) int {
+	x := t.M()
+	y := other(x)
+	z := local(y)
+	return z + i.M()
+}
+
+func local(x int) int { return x }
+`,
+		b: `package p
+
+type T struct{ n int }
+
+func (t *T) M() int { return t.n }
+
+func other(x int) int { return x + 1 }
+`,
+	}
+	for name, src := range files {
+		if err := os.WriteFile(name, []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	canInline := func(cost string) *lsp.Diagnostic {
+		return &lsp.Diagnostic{Code: "canInlineFunction", Message: "cost: " + cost}
+	}
+	inl := map[fileLine]*lsp.Diagnostic{
+		{b, 5}:  canInline("4"), // (*T).M, a method in another file
+		{b, 7}:  canInline("5"), // other, in another file
+		{a, 12}: canInline("2"), // local
+	}
+
+	pf := parseSource(a)
+	costs := func(inl map[fileLine]*lsp.Diagnostic) map[int]int {
+		byLine := make(map[int]int)
+		for _, sc := range statementCosts(pf, funcAt(pf, 5), inl) {
+			byLine[sc.line] = sc.cost
+		}
+		return byLine
+	}
+	with, without := costs(inl), costs(nil)
+	// Each inlinable callee costs its own cost instead of the extra call cost.
+	for line, callee := range map[int]int{6: 4, 7: 5, 8: 2, 9: inlineExtraCallCost} {
+		if got, want := without[line]-with[line], inlineExtraCallCost-callee; got != want {
+			t.Errorf("line %d: inlinable callee lowers the cost by %d, want %d", line, got, want)
+		}
+	}
+	if len(with) != 4 {
+		t.Errorf("costs for %d statements, want 4: %v", len(with), with)
+	}
+}
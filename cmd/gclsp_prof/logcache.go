@@ -0,0 +1,192 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// The compiler only writes diagnostics for the packages it actually compiles,
+// so after a build that hits in the build cache, an lspdir may be missing some
+// or all of them.  For -bench, completeDiagnostics finds the missing packages
+// with go list, and fills them in from a cache of diagnostics kept by build
+// action ID (see logCacheDir), or else by recompiling just those packages.
+
+// listedPackage is a package compiled for the test binary, as listed by go list.
+type listedPackage struct {
+	importPath string // without any " [p.test]" suffix
+	name       string
+	actionID   string
+}
+
+// dir returns the name of the subdirectory of an lspdir that the compiler
+// writes the diagnostics of p to.
+func (p listedPackage) dir() string {
+	if p.name == "main" {
+		return "main"
+	}
+	return url.PathEscape(p.importPath)
+}
+
+// logCacheDir returns the directory of the cache of diagnostics, in which
+// those of each package compiled with -json are in a subdirectory named
+// by the compilation's action ID.
+func logCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gclsp_prof", "diagnostics"), nil
+}
+
+// parseListedPackages parses the output of go list -f '{{.ImportPath}}\t{{.Name}}\t{{.BuildID}}'
+// for the packages of a test, keeping those in the set selected (all of them,
+// if selected is nil) and, for a package that was recompiled for the test, only
+// the test's variant of it.  The test's main package is kept if its package is.
+func parseListedPackages(out []byte, selected map[string]bool) []listedPackage {
+	var pkgs []listedPackage
+	var variant []bool
+	forTest := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 3 || fields[2] == "" {
+			continue // e.g., unsafe
+		}
+		p := listedPackage{importPath: fields[0], name: fields[1], actionID: strings.Split(fields[2], "/")[0]}
+		i := strings.Index(p.importPath, " [")
+		if i >= 0 {
+			p.importPath = p.importPath[:i]
+			forTest[p.importPath] = true
+		}
+		pkgs = append(pkgs, p)
+		variant = append(variant, i >= 0)
+	}
+	var result []listedPackage
+	for i, p := range pkgs {
+		if forTest[p.importPath] && !variant[i] {
+			continue
+		}
+		if selected != nil && !selected[p.importPath] && !selected[strings.TrimSuffix(p.importPath, ".test")] {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// listPackages returns the packages that go test compiles with gcflags (for
+// the -packages) and the other build flags.
+func listPackages(gcflags string, buildFlags []string) ([]listedPackage, error) {
+	pattern := strings.TrimSuffix(packages, "=")
+	var selected map[string]bool
+	if pattern != "all" {
+		if pattern == "" {
+			pattern = "."
+		}
+		out, err := exec.Command("go", "list", pattern).Output()
+		if err != nil {
+			return nil, fmt.Errorf("go list %s: %v", pattern, err)
+		}
+		selected = make(map[string]bool)
+		for _, p := range strings.Fields(string(out)) {
+			selected[p] = true
+		}
+	}
+	args := []string{"list", "-test", "-deps", "-export", "-gcflags=" + packages + gcflags}
+	args = append(args, buildFlags...)
+	args = append(args, "-f", "{{.ImportPath}}\t{{.Name}}\t{{.BuildID}}", ".")
+	out, err := exec.Command("go", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list -export: %v", err)
+	}
+	return parseListedPackages(out, selected), nil
+}
+
+// completeDiagnostics makes sure that lspdir, just written by go test with
+// -gcflags=<-packages>-json=0,lspdir and buildFlags, has the diagnostics
+// of every package compiled.  Those that are there are saved in the log
+// cache; those that are not are copied from there, or if they are not there
+// either, the packages are recompiled, into a temporary directory so that
+// the build cache cannot hit.
+func completeDiagnostics(lspdir string, buildFlags []string) error {
+	cache, err := logCacheDir()
+	if err != nil {
+		return err
+	}
+	// What go test wrote, before go list -export compiles anything else.
+	written := make(map[string]bool)
+	entries, _ := os.ReadDir(lspdir)
+	for _, e := range entries {
+		written[e.Name()] = true
+	}
+	pkgs, err := listPackages("-json=0,"+lspdir, buildFlags)
+	if err != nil {
+		return err
+	}
+	var missing []listedPackage
+	for _, p := range pkgs {
+		have, cached := filepath.Join(lspdir, p.dir()), filepath.Join(cache, p.actionID)
+		if written[p.dir()] {
+			if _, err := os.Stat(cached); err != nil {
+				if err := copyDir(have, cached); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if _, err := os.Stat(cached); err == nil {
+			if verbose > 0 {
+				fmt.Fprintf(os.Stderr, "Diagnostics for %s from %s\n", p.importPath, cached)
+			}
+			if err := copyDir(cached, have); err != nil {
+				return err
+			}
+			continue
+		}
+		missing = append(missing, p)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	tmp, err := ioutil.TempDir("", "GcLspProfRecompile")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	args := []string{"test", "-c", "-o", os.DevNull}
+	args = append(args, buildFlags...)
+	for _, p := range missing {
+		args = append(args, "-gcflags="+p.importPath+"=-json=0,"+tmp)
+	}
+	args = append(args, ".")
+	runCmd(exec.Command("go", args...))
+	for _, p := range missing {
+		from, cached := filepath.Join(tmp, p.dir()), filepath.Join(cache, p.actionID)
+		if _, err := os.Stat(from); err != nil {
+			// No diagnostics; remember that, so as not to recompile next time.
+			if err := os.MkdirAll(cached, 0777); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := copyDir(from, filepath.Join(lspdir, p.dir())); err != nil {
+			return err
+		}
+		if err := copyDir(from, cached); err != nil {
+			return err
+		}
+	}
+	return nil
+}
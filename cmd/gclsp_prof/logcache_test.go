@@ -0,0 +1,41 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseListedPackages(t *testing.T) {
+	out := []byte(`internal/goarch	goarch	fAXQ4ufam2YAJ-yQDtq0/NT3-L_hI5OXTup5HURwN
+unsafe	unsafe
+example.com/p	p	M4qKYrcGSXqxAJZs91ox/WxFZabmlZyoIegKwQVKC
+example.com/p [example.com/p.test]	p	a5gcvAtCGbiUSedLYnob/_fzl-xuuFln3WYunbgRe
+example.com/p_test [example.com/p.test]	p_test	b5gcvAtCGbiUSedLYnob/_fzl-xuuFln3WYunbgRe
+example.com/p.test	main	5DJBM-VkAohbIeMxG35v/oBJPMKLgJcfe2K8Uy_ik
+`)
+	show := func(pkgs []listedPackage) string {
+		var s []string
+		for _, p := range pkgs {
+			s = append(s, fmt.Sprintf("%s %s %s", p.importPath, p.dir(), p.actionID))
+		}
+		return strings.Join(s, "; ")
+	}
+
+	got := show(parseListedPackages(out, map[string]bool{"example.com/p": true}))
+	want := "example.com/p example.com%2Fp a5gcvAtCGbiUSedLYnob; example.com/p.test main 5DJBM-VkAohbIeMxG35v"
+	if got != want {
+		t.Errorf("selected: got %s, want %s", got, want)
+	}
+
+	got = show(parseListedPackages(out, nil))
+	want = "internal/goarch internal%2Fgoarch fAXQ4ufam2YAJ-yQDtq0; example.com/p example.com%2Fp a5gcvAtCGbiUSedLYnob; " +
+		"example.com/p_test example.com%2Fp_test b5gcvAtCGbiUSedLYnob; example.com/p.test main 5DJBM-VkAohbIeMxG35v"
+	if got != want {
+		t.Errorf("all: got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+)
+
+// isCheck reports whether code is that of a bounds or nil check.
+func isCheck(code string) bool {
+	return code == "isInBounds" || code == "isSliceInBounds" || code == "nilcheck"
+}
+
+// loopVariable returns the induction variable of loop, the key of a range
+// loop or the variable initialized and stepped by a for loop, or "".
+func loopVariable(loop ast.Stmt) string {
+	switch l := loop.(type) {
+	case *ast.RangeStmt:
+		if key, ok := l.Key.(*ast.Ident); ok && key.Name != "_" {
+			return key.Name
+		}
+	case *ast.ForStmt:
+		init, ok := l.Init.(*ast.AssignStmt)
+		if !ok || len(init.Lhs) != 1 {
+			return ""
+		}
+		iv, ok := init.Lhs[0].(*ast.Ident)
+		if !ok {
+			return ""
+		}
+		switch post := l.Post.(type) {
+		case *ast.IncDecStmt:
+			if x, ok := post.X.(*ast.Ident); ok && x.Name == iv.Name {
+				return iv.Name
+			}
+		case *ast.AssignStmt:
+			if x, ok := post.Lhs[0].(*ast.Ident); ok && len(post.Lhs) == 1 && x.Name == iv.Name {
+				return iv.Name
+			}
+		}
+	}
+	return ""
+}
+
+// loopFacts returns the loop nesting depth of the check diagnosed by m,
+// including the loops around the call sites it is inlined at, and for a
+// bounds check whose index mentions the induction variable of one of the
+// loops around it, that variable.  For a check in inlined code, the index
+// is followed from the inlined function's parameters to the arguments
+// of the call.
+func loopFacts(m *Match) (depth int, induction string) {
+	file, line, col := innermostPosition(m)
+	pf := parseSource(file)
+	if pf == nil {
+		return 0, ""
+	}
+	path := pf.pathTo(line, col)
+	var index []ast.Expr
+	if m.Code != "nilcheck" {
+		if _, ix := checkedIndex(path, m.Code); ix != nil {
+			index = []ast.Expr{ix}
+		}
+	}
+
+	// The call sites of inlined code, outermost first
+	var sites []Position
+	if len(m.Inlines) > 0 {
+		sites = append(sites, m.Position)
+		for _, il := range m.Inlines[:len(m.Inlines)-1] {
+			sites = append(sites, il.Position)
+		}
+	}
+
+	for level := len(sites); ; level-- {
+		loops := enclosingLoops(path)
+		depth += len(loops)
+		for i := len(loops) - 1; i >= 0 && induction == ""; i-- {
+			if iv := loopVariable(loops[i]); iv != "" && mentionsAny(index, iv) {
+				induction = iv
+			}
+		}
+		if level == 0 {
+			break
+		}
+		fn := enclosingFunc(path)
+		site := sites[level-1]
+		pf = parseSource(site.File)
+		if pf == nil {
+			break
+		}
+		var call *ast.CallExpr
+		if fd, ok := fn.(*ast.FuncDecl); ok {
+			for _, c := range nodesOnLine[*ast.CallExpr](pf, int(site.Line)) {
+				if calledName(c) == fd.Name.Name {
+					call = c
+					break
+				}
+			}
+			index = arguments(fd, call, index)
+		} else {
+			index = nil
+		}
+		if call != nil {
+			path = pf.pathTo(pf.lineOf(call), pf.fset.Position(call.Pos()).Column)
+		} else {
+			path = pf.pathTo(int(site.Line), 0)
+		}
+	}
+	return depth, induction
+}
+
+// arguments returns the arguments (and receiver) of call that are passed
+// to the parameters of fd mentioned in exprs.
+func arguments(fd *ast.FuncDecl, call *ast.CallExpr, exprs []ast.Expr) []ast.Expr {
+	if call == nil || len(exprs) == 0 {
+		return nil
+	}
+	var result []ast.Expr
+	if fd.Recv != nil && len(fd.Recv.List) > 0 {
+		if sel, ok := call.Fun.(*ast.SelectorExpr); ok {
+			for _, name := range fd.Recv.List[0].Names {
+				if mentionsAny(exprs, name.Name) {
+					result = append(result, sel.X)
+				}
+			}
+		}
+	}
+	i := 0
+	for _, field := range fd.Type.Params.List {
+		names := field.Names
+		if len(names) == 0 {
+			i++
+			continue
+		}
+		for _, name := range names {
+			if i < len(call.Args) && mentionsAny(exprs, name.Name) {
+				result = append(result, call.Args[i])
+			}
+			i++
+		}
+	}
+	return result
+}
+
+// mentionsAny reports whether any of exprs refers to the identifier name.
+func mentionsAny(exprs []ast.Expr, name string) bool {
+	for _, e := range exprs {
+		if mentions(e, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// loopWeight is the factor by which the expected payoff of removing the
+// check diagnosed by m is increased for its loop nesting: more deeply
+// nested checks are more likely to be executed often, and a check indexed
+// by an induction variable can usually be removed by one hint before its loop.
+func loopWeight(m *Match) float64 {
+	if !isCheck(m.Code) {
+		return 1
+	}
+	w := 1 + 0.5*float64(m.LoopDepth)
+	if m.Induction != "" {
+		w *= 2
+	}
+	return w
+}
+
+// describeLoops describes the loop facts of m, e.g. ", loop depth 2, index i", or "".
+func describeLoops(m *Match) string {
+	s := ""
+	if m.LoopDepth > 0 {
+		s = fmt.Sprintf(", loop depth %d", m.LoopDepth)
+	}
+	if m.Induction != "" {
+		s += ", index " + m.Induction
+	}
+	return s
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+)
+
+func TestLoopVariable(t *testing.T) {
+	tests := map[string]string{
+		"for i := 0; i < n; i++ {}":       "i",
+		"for i := n; i > 0; i-- {}":       "i",
+		"for i := 0; i < n; i += 2 {}":    "i",
+		"for i := 0; i < n; j++ {}":       "",
+		"for i, j := 0, n; i < j; i++ {}": "",
+		"for k := range m {}":             "k",
+		"for _, v := range xs {}":         "",
+		"for range xs {}":                 "",
+		"for {}":                          "",
+	}
+	for src, want := range tests {
+		f, err := parser.ParseFile(token.NewFileSet(), "", "package p; func f() {"+src+"}", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loop := f.Decls[0].(*ast.FuncDecl).Body.List[0]
+		if got := loopVariable(loop); got != want {
+			t.Errorf("loopVariable(%s) = %q, want %q", src, got, want)
+		}
+	}
+}
+
+const loopsSource = `package p
+
+func at(xs []int, k int) int {
+	return xs[k] // at
+}
+
+func sum(xs []int, n int) (s int) {
+	for i := 0; i < n; i++ {
+		for j := range xs {
+			s += xs[j] // nested
+		}
+		s += at(xs, i) // call
+		s += at(xs, 0) // constant
+	}
+	return
+}
+
+func deref(p *int) int {
+	return *p // deref
+}
+
+func stepped(m [][]int) (s int) {
+	for _, row := range m {
+		for k := 0; k < len(row); k += 2 {
+			s += row[k+1] // stepped
+		}
+	}
+	return
+}
+`
+
+func TestLoopFacts(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "p.go")
+	if err := os.WriteFile(file, []byte(loopsSource), 0666); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(loopsSource, "\n")
+	find := func(marker string) (line int, col uint) {
+		for i, l := range lines {
+			if strings.HasSuffix(l, "// "+marker) {
+				return i + 1, uint(strings.IndexAny(l, "[*") + 1)
+			}
+		}
+		t.Fatalf("no line marked %s", marker)
+		return 0, 0
+	}
+	// match returns a diagnostic with code at the line marked by marker, or
+	// if inlined is not "", at the call marked by inlined of the function
+	// containing that line.
+	match := func(code, marker, inlined string) *Match {
+		line, col := find(marker)
+		d := &lsp.Diagnostic{Code: code}
+		m := &Match{Code: code, diagnostic: d}
+		if inlined == "" {
+			d.Range.Start.Line, d.Range.Start.Character = uint(line), col
+			m.Position = Position{File: file, Line: int64(line)}
+			return m
+		}
+		outer, _ := find(inlined)
+		d.Range.Start.Line = uint(outer)
+		var ri lsp.DiagnosticRelatedInformation
+		ri.Message = "inlineLoc"
+		ri.Location.URI = lsp.DocumentURI("file://" + file)
+		ri.Location.Range.Start.Line, ri.Location.Range.Start.Character = uint(line), col
+		d.RelatedInformation = []lsp.DiagnosticRelatedInformation{ri}
+		m.Position = Position{File: file, Line: int64(outer)}
+		m.Inlines = []InlineMatch{{Position: Position{File: file, Line: int64(line)}}}
+		return m
+	}
+
+	tests := []struct {
+		m         *Match
+		depth     int
+		induction string
+	}{
+		{match("isInBounds", "nested", ""), 2, "j"},
+		{match("isInBounds", "at", ""), 0, ""},
+		// Inlined into a loop, indexed by its variable through a parameter.
+		{match("isInBounds", "at", "call"), 1, "i"},
+		{match("isInBounds", "at", "constant"), 1, ""},
+		{match("nilcheck", "deref", ""), 0, ""},
+		{match("isInBounds", "stepped", ""), 2, "k"},
+	}
+	for _, tc := range tests {
+		depth, induction := loopFacts(tc.m)
+		if depth != tc.depth || induction != tc.induction {
+			t.Errorf("loopFacts(%s at line %d, inlined %d) = %d, %q; want %d, %q",
+				tc.m.Code, tc.m.Position.Line, len(tc.m.Inlines), depth, induction, tc.depth, tc.induction)
+		}
+	}
+}
+
+func TestLoopWeight(t *testing.T) {
+	tests := []struct {
+		code      string
+		depth     int
+		induction string
+		want      float64
+	}{
+		{"isInBounds", 0, "", 1},
+		{"isInBounds", 2, "", 2},
+		{"isInBounds", 2, "i", 4},
+		{"nilcheck", 1, "", 1.5},
+		{"isSliceInBounds", 1, "k", 3},
+		{"escape", 3, "", 1},
+	}
+	for _, tc := range tests {
+		m := &Match{Code: tc.code, LoopDepth: tc.depth, Induction: tc.induction}
+		if got := loopWeight(m); got != tc.want {
+			t.Errorf("loopWeight(%s, depth %d, index %q) = %g, want %g", tc.code, tc.depth, tc.induction, got, tc.want)
+		}
+	}
+}
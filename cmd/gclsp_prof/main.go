@@ -14,6 +14,8 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime/pprof"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/dr2chase/gc-lsp-tools/lsp"
@@ -35,6 +37,10 @@ var abbreviations []abbreviation
 var bench string
 var keep string
 var packages string
+var compare string
+var experiments reuse.RepeatedString
+var pgo string
+var scope = "all"
 
 var verbose reuse.Count
 var before = int64(0)
@@ -42,10 +48,40 @@ var after = int64(0)
 var explain = false
 var cpuprofile = ""
 var threshold = 1.0
+var top = 0
+var cover = ""
+var cutoff = "" // describes how threshold was chosen, if by -top or -cover
 var filter = ""
 var filterRE *regexp.Regexp
-
-// gclsp_prof [-v] [-e] [-a=n] [-b=n] [-f=RE] [-t=f.f] [-s=EVs] [-cpuprofile=file]  lspdir profile1 [ profile2 ... ]
+var binary = ""
+var runs = false
+var confidence = 0.95
+var significant = false
+var jsonOut = ""
+var htmlOut = ""
+var rank = false
+var costFile = ""
+var showHints = false
+var hintDiff = ""
+var stale = false
+var pathmaps reuse.RepeatedString
+var check = "warn"
+var target = ""
+var archDiff = false
+var instances = false
+var profileURLs reuse.RepeatedString
+var charge = false
+var inlining = false
+var nearMiss = 1.5
+var callTreeFuncs = ""
+var copies = false
+var allocMode = ""
+var sampleType = "" // of the profile values counted, set by -alloc
+var chargeList = ""
+var writeBaselineFile = ""
+var baselineFile = ""
+
+// gclsp_prof [-v] [-e] [-a=n] [-b=n] [-f=RE] [-t=f.f] [-s=EVs] [-binary=exe] [-cpuprofile=file]  lspdir profile1 [ profile2 ... ]
 // Produces a summary of optimizations (if any) that were not or could not be applied at hotspots in the profile.
 func main() {
 
@@ -56,11 +92,43 @@ func main() {
 
 	flag.StringVar(&filter, "f", filter, "Reported tags should match filter")
 	flag.Float64Var(&threshold, "t", threshold, "Threshold percentage below which profile entries will be ignored")
+	flag.IntVar(&top, "top", top, "Instead of -t, report the N hottest profile entries")
+	flag.StringVar(&cover, "cover", cover, "Instead of -t, report the hottest profile entries that together account for this percentage of samples, e.g. 80%")
+	flag.BoolVar(&runs, "runs", runs, "Treat each profile as a separate run of the same program, and report variation across runs")
+	flag.Float64Var(&confidence, "conf", confidence, "Confidence level for hotspot percentage intervals")
+	flag.BoolVar(&significant, "sig", significant, "Ignore hotspots whose confidence interval does not lie entirely above the threshold")
+	flag.Var(&pathmaps, "pathmap", "Rewrite file names in directory old to start with new, for old=new (repeatable), to locate sources from elsewhere")
+	flag.StringVar(&check, "check", check, "Check that the diagnostics match the profiled build and sources: off, warn, or strict (refuse to report on a mismatch)")
+	flag.StringVar(&target, "target", target, "Use the diagnostics for this goos/goarch, if the lspdir has several (default: the profiled binary's)")
+	flag.BoolVar(&archDiff, "arch-diff", archDiff, "Also report hot diagnostics that differ between the targets in the lspdir")
+	flag.StringVar(&scope, "scope", scope, "Report only hot spots in these comma-separated scopes: module (the main module), deps (dependencies), goroot (standard library and runtime), or all; the weight of the others is summarized")
+	flag.BoolVar(&instances, "instances", instances, "Report hot spots in generic functions separately for each instantiation (naming their shapes may need -binary)")
+	flag.StringVar(&allocMode, "alloc", allocMode, "Read heap or allocs profiles instead of cpu profiles, and report the hottest allocation sites by space or objects allocated, with the diagnostics explaining why they escape")
+	flag.BoolVar(&inlining, "inlining", inlining, "Instead of the usual report, report hot functions (by cumulative samples) that narrowly missed inlining, and hot calls that were not inlined")
+	flag.Float64Var(&nearMiss, "near-miss", nearMiss, "For -inlining, report functions whose inlining cost is at most this multiple of the budget")
+	flag.StringVar(&callTreeFuncs, "call-tree", callTreeFuncs, "Instead of the usual report, show the calls in the hot functions whose names match this regexp, whether each was inlined (and if not, why), and their weights, following inlined calls into their callees")
+	flag.BoolVar(&copies, "copies", copies, "Instead of the usual report, list the hot copies of large values by bytes copied times sample percent, with the type copied and how")
+	flag.BoolVar(&charge, "charge", charge, "Charge samples in runtime allocation, bounds check, write barrier and copying functions to the user code calling them")
+	flag.StringVar(&chargeList, "charge-funcs", chargeList, "For -charge, the comma-separated runtime functions to charge, with a trailing * matching any suffix (default: prof.DefaultCharged)")
 	flag.StringVar(&shortenEVs, "s", shortenEVs, "Environment variables used to abbreviate file names in output")
-
+	flag.StringVar(&binary, "binary", binary, "Symbolize profile addresses lacking file and line information using this (profiled) binary")
+
+	flag.StringVar(&jsonOut, "json", jsonOut, "Write the report as JSON to this file (- for standard output) instead of as text")
+	flag.StringVar(&htmlOut, "html", htmlOut, "Also write the report as a self-contained HTML page of annotated source to this file")
+	flag.BoolVar(&rank, "rank", rank, "Report diagnostics ranked by expected payoff, sample percentage times estimated cost")
+	flag.StringVar(&costFile, "costs", costFile, "Read estimated costs of diagnostics from this file, lines of 'code base [perByte]'")
+	flag.BoolVar(&showHints, "hints", showHints, "Suggest source changes for hot diagnostics")
+	flag.StringVar(&hintDiff, "hint-diff", hintDiff, "Write the suggested source changes that can be made mechanically to this file, as a unified diff")
+	flag.StringVar(&writeBaselineFile, "write-baseline", writeBaselineFile, "Write the hot diagnostics to this file, as a baseline for -baseline")
+	flag.StringVar(&baselineFile, "baseline", baselineFile, "Report only hot diagnostics not in this baseline file, and exit with status 1 if there are any")
+	flag.BoolVar(&stale, "stale", stale, "Also report //gclsp:ignore suppressions that no longer match any diagnostic")
+	flag.Var(&profileURLs, "profile-url", "Fetch a profile from this URL (repeatable), e.g. http://localhost:6060/debug/pprof/profile?seconds=30, in addition to any profile files")
 	flag.StringVar(&cpuprofile, "cpuprofile", cpuprofile, "Record a cpu profile in this file")
 	flag.StringVar(&bench, "bench", bench, "Run 'bench' benchmarks in current directory and reports hotspot(s). Passes -bench=whatever to go test, as well as arguments past --")
 	flag.StringVar(&keep, "keep", keep, "For -bench, keep the intermedia results in <-keep>.lspdir and <-keep>.prof")
+	flag.StringVar(&compare, "compare", compare, "For -bench, compare against the artifacts of a previous run in <-compare>.{lspdir,prof,bench}, first moving any previous <-keep> artifacts there")
+	flag.Var(&experiments, "experiment", "For -bench, also run the benchmarks with these compiler flags (repeatable), e.g. -B or '-l -B', and report the speedup overall and per hot line")
+	flag.StringVar(&pgo, "pgo", pgo, "For -bench, build with this PGO profile (see 'go help build'), and unless -compare or -experiment, also build without it and report what PGO changed at hot spots")
 	flag.StringVar(&packages, "packages", packages, "For -bench, get diagnostics for the listed packages (see 'go help packages')")
 
 	usage := func() {
@@ -68,7 +136,7 @@ func main() {
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr,
 			`
-%s LspDir Profile1 [ Profile2 ... ] reads the supplied cpu profiles to
+%s LspDir Profile1 [ Profile2 ... ] reads the supplied cpu profiles (or those from -profile-url) to
 determine the hotspots in an application, then reads the compiler logging
 information in LspDir to match missed optimizations against hotspots.
 `, os.Args[0])
@@ -78,8 +146,18 @@ information in LspDir to match missed optimizations against hotspots.
 
 	flag.Parse()
 
+	var err error
+	if sampleType, err = allocSampleType(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
 	if filter != "" {
 		filterRE = regexp.MustCompile(filter)
+	} else if sampleType != "" {
+		filterRE = allocationCodes
+	} else if copies {
+		filterRE = regexp.MustCompile(`^copy$`)
 	}
 
 	if cpuprofile != "" {
@@ -101,12 +179,92 @@ information in LspDir to match missed optimizations against hotspots.
 		}
 	}
 
+	switch check {
+	case "off", "warn", "strict":
+	default:
+		fmt.Fprintf(os.Stderr, "-check=%s, expected off, warn, or strict\n", check)
+		os.Exit(1)
+	}
+
+	if err := parseScope(scope); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	if err := parsePathMaps(pathmaps); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	if costFile != "" {
+		if err := readCosts(costFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not read costs, %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	args := flag.Args()
 
+	if compare != "" && bench == "" {
+		fmt.Fprintf(os.Stderr, "-compare requires -bench\n")
+		os.Exit(1)
+	}
+	if compare != "" && keep != "" && filepath.Clean(compare) == filepath.Clean(keep) {
+		fmt.Fprintf(os.Stderr, "-compare and -keep must name different runs, the new run would replace %s\n", compare)
+		os.Exit(1)
+	}
+	if len(experiments) > 0 && (bench == "" || compare != "") {
+		fmt.Fprintf(os.Stderr, "-experiment requires -bench, and not -compare\n")
+		os.Exit(1)
+	}
+	if pgo != "" && bench == "" {
+		fmt.Fprintf(os.Stderr, "-pgo requires -bench\n")
+		os.Exit(1)
+	}
+
 	if bench != "" {
+		if compare != "" && keep != "" {
+			if err := saveArtifacts(keep, compare); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not save previous run, %v\n", err)
+				os.Exit(1)
+			}
+		}
 		var cleanup func()
-		args, cleanup = runBench(args)
+		var variants []artifacts
+		args, variants, cleanup = runBench(args)
 		defer cleanup()
+		if len(variants) > 0 {
+			if err := reportExperiments(artifactsFor(strings.TrimSuffix(args[1], ".prof")), variants); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not compare experiments, %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		if pgo != "" && compare == "" {
+			base := strings.TrimSuffix(args[1], ".prof")
+			if err := reportPGO(artifactsFor(base), artifactsFor(base+".nopgo").lspdir, base+".devirt"); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not compare PGO, %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		if compare != "" {
+			if err := reportComparison(artifactsFor(compare), artifactsFor(strings.TrimSuffix(args[1], ".prof"))); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not compare runs, %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	for _, u := range profileURLs {
+		file, cleanup, err := fetchProfile(u)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not fetch profile, %v\n", err)
+			os.Exit(1)
+		}
+		defer cleanup()
+		args = append(args, file)
 	}
 
 	if len(args) < 2 {
@@ -117,146 +275,354 @@ information in LspDir to match missed optimizations against hotspots.
 	profiles := args[1:]
 
 	// pi, err := prof.FromTextOutput(profiles)
-	pi, err := prof.FromProtoBuf(profiles, true, false, int(verbose))
+	pi, err := readProfiles(profiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read profiles, %v\n", err)
+		os.Exit(1)
+	}
 
 	if len(pi) == 0 {
 		return
 	}
 
+	if err := chooseThreshold(pi); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
 	if verbose > 0 {
 		for _, p := range pi {
-			if p.FlatPercent >= threshold {
+			if isHot(p) {
 				fmt.Printf("%f%%, %s:%d\n", p.FlatPercent, p.FileLine[0].SourceFile, p.FileLine[0].Line)
 			}
 		}
 	}
 
-	byFile := make(map[string]*lsp.CompilerDiagnostics)
-	err = lsp.ReadAll(lspDir, byFile, int(verbose))
+	byFile, suppressions, err := readDiagnostics(lspDir, profiles)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "Could not read diagnostics, %v\n", err)
+		os.Exit(1)
+	}
+	remapProfile(pi, byFile)
+	if !reportConsistency(profiles, byFile) {
+		fmt.Fprintf(os.Stderr, "Diagnostics do not match the profile (see -check)\n")
+		os.Exit(1)
+	}
+
+	if callTreeFuncs != "" {
+		re, err := regexp.Compile(callTreeFuncs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-call-tree=%s, %v\n", callTreeFuncs, err)
+			os.Exit(1)
+		}
+		if err := reportCallTree(re, profiles, byFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not report calls, %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if inlining {
+		if err := reportInlining(profiles, byFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not report on inlining, %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	r := buildReport(pi, byFile)
+	if jsonOut == "" {
+		defer reportHidden(r)
+	}
+	if archDiff && jsonOut == "" {
+		defer reportArchDiffs(r)
+	}
+	if stale {
+		r.Stale = staleSuppressions(suppressions)
+		if jsonOut == "" {
+			defer reportStale(r)
+		}
+	}
+	if htmlOut != "" {
+		if err := writeHTML(r, pi, htmlOut); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write HTML report, %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if writeBaselineFile != "" {
+		if err := writeBaseline(r, writeBaselineFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write baseline, %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if baselineFile != "" {
+		n, err := reportBaseline(r, baselineFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not compare with baseline, %v\n", err)
+			os.Exit(1)
+		}
+		if n > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if jsonOut != "" {
+		if err := writeJSON(r, jsonOut); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write JSON report, %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if hintDiff != "" {
+		var hs []*hint
+		for _, h := range r.Hotspots {
+			for _, m := range h.Diagnostics {
+				hs = append(hs, m.hint)
+			}
+		}
+		if err := writeHintDiff(hs, hintDiff); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write hint diff, %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if sampleType != "" {
+		reportAllocations(r)
+		return
+	}
+	if copies {
+		reportCopies(r)
+		return
+	}
+	if rank {
+		reportRanked(r)
+		return
 	}
+	reportPlain(r)
 
-	reportPlain(pi, byFile)
+}
 
+// readProfiles reads the profiles, either combining them into one,
+// or if -runs, merging them as separate runs.
+func readProfiles(profiles []string) ([]*prof.ProfileItem, error) {
+	opts := prof.Options{Combine: true, Binary: binary, Instances: instances, SampleType: sampleType, Verbose: int(verbose)}
+	if charge {
+		opts.Charge = chargeFuncs()
+	}
+	if !runs || len(profiles) == 1 {
+		return prof.FromProtoBufOptions(profiles, opts)
+	}
+	var each [][]*prof.ProfileItem
+	for _, p := range profiles {
+		pi, err := prof.FromProtoBufOptions([]string{p}, opts)
+		if err != nil {
+			return nil, err
+		}
+		each = append(each, pi)
+	}
+	return prof.MergeRuns(each), nil
 }
 
-func reportPlain(pi []*prof.ProfileItem, byFile map[string]*lsp.CompilerDiagnostics) {
-	near := func(d *lsp.Diagnostic, line int64) bool {
-		diagStart := int64(d.Range.Start.Line)
-		diagEnd := int64(d.Range.End.Line)
-		return line-before <= diagStart && diagEnd <= line+after
+// readDiagnostics reads the diagnostics in lspDir for the target of profiles
+// (see selectTarget), indexed by (local) source file, and removes those
+// suppressed by //gclsp:ignore directives.
+func readDiagnostics(lspDir string, profiles []string) (map[string]*lsp.CompilerDiagnostics, *lsp.Suppressions, error) {
+	byTarget, err := lsp.ReadAllTargets(lspDir, int(verbose))
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := selectTarget(byTarget, profiles)
+	if err != nil {
+		return nil, nil, err
+	}
+	byFile := byTarget[t]
+	if byFile == nil {
+		byFile = make(map[string]*lsp.CompilerDiagnostics)
+	}
+	remapDiagnostics(byFile)
+	suppressions := lsp.ReadSuppressions(byFile)
+	n := suppressions.Filter(byFile)
+	for _, other := range otherTargets {
+		remapDiagnostics(other)
+		suppressions.Filter(other)
 	}
+	if verbose > 0 {
+		fmt.Fprintf(os.Stderr, "%d diagnostics suppressed by %d //gclsp:ignore directives\n", n, len(suppressions.All()))
+	}
+	return byFile, suppressions, nil
+}
 
-	tab := "        " // Tabs vary, we want 8.
+// reportStale prints the suppressions that matched no diagnostic.
+func reportStale(r *Report) {
+	if len(r.Stale) == 0 {
+		return
+	}
+	fmt.Printf("Stale suppressions (matching no diagnostic):\n")
+	for _, s := range r.Stale {
+		reason := ""
+		if s.Reason != "" {
+			reason = ", " + s.Reason
+		}
+		fmt.Printf("%8s%s:%d, %s (%s)%s\n", "", shorten(s.File), s.Line, s.Code, s.Scope, reason)
+	}
+}
 
-	for _, p := range pi {
-		if p.FlatPercent >= threshold {
-			cd := byFile[p.FileLine[0].SourceFile]
-			if cd != nil && len(cd.Diagnostics) > 0 {
-				printedProfileLine := false
-				profileInlines := p.FileLine[1:]
-				for i, fl := range p.FileLine {
-					p.FileLine[i].SourceFile = shorten(fl.SourceFile)
-				}
-				fl := p.FileLine[0]
+// chooseThreshold sets the threshold from -top or -cover, if either was
+// specified, to the percentage of the last profile entry to be reported,
+// and describes that choice in cutoff.  Entries tied with that last
+// entry are also reported.
+func chooseThreshold(pi []*prof.ProfileItem) error {
+	if top == 0 && cover == "" {
+		return nil
+	}
+	if top != 0 && cover != "" {
+		return fmt.Errorf("-top and -cover are mutually exclusive")
+	}
+	if top < 0 {
+		return fmt.Errorf("-top=%d must be positive", top)
+	}
+	want := 0.0
+	if cover != "" {
+		var err error
+		want, err = strconv.ParseFloat(strings.TrimSuffix(cover, "%"), 64)
+		if err != nil || want <= 0 || want > 100 {
+			return fmt.Errorf("-cover=%s must be a percentage between 0 and 100", cover)
+		}
+	}
+	if len(pi) == 0 {
+		return nil // nothing is hot anyway
+	}
+	sorted := append([]*prof.ProfileItem(nil), pi...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].FlatPercent > sorted[j].FlatPercent })
+	// Entries tied with the last one chosen are at the threshold, so they are hot too.
+	ties := func(n int) string {
+		k := n
+		for k < len(sorted) && sorted[k].FlatPercent >= threshold {
+			k++
+		}
+		if k == n {
+			return ""
+		}
+		return fmt.Sprintf(" (%d with ties)", k)
+	}
 
-				// Defer printing profile line till at least one diagnostic is shown to match
-				for _, d := range cd.Diagnostics {
-					if d.Code == "inlineCall" { // Don't want to see these, they are confusing and eventually removed..
-						continue
-					}
+	if top > 0 {
+		n := top
+		if n > len(sorted) {
+			n = len(sorted)
+		}
+		threshold = sorted[n-1].FlatPercent
+		cutoff = fmt.Sprintf("top %d profile entries%s, threshold %.2f%%", n, ties(n), threshold)
+		return nil
+	}
 
-					if filterRE != nil && !filterRE.MatchString(d.Code) {
-						continue
-					}
+	covered := 0.0
+	n := 0
+	for _, p := range sorted {
+		covered += p.FlatPercent
+		n++
+		threshold = p.FlatPercent
+		if covered >= want {
+			break
+		}
+	}
+	cutoff = fmt.Sprintf("hottest %d profile entries%s, covering %.1f%% of samples, threshold %.2f%%", n, ties(n), covered, threshold)
+	return nil
+}
 
-					if !near(d, fl.Line) {
-						continue
-					}
-					if !printedProfileLine {
-						printedProfileLine = true
+// isHot reports whether p is at or above the threshold, and if -sig,
+// whether the lower end of its confidence interval is too.
+func isHot(p *prof.ProfileItem) bool {
+	if p.FlatPercent < threshold {
+		return false
+	}
+	if significant {
+		lo, _ := p.Interval(confidence)
+		return lo >= threshold
+	}
+	return true
+}
 
-						fmt.Printf("%5.1f%%, %s:%d)\n", p.FlatPercent, fl.SourceFile, fl.Line)
+// statistics returns the confidence interval and run-to-run variation of p
+// for display, if either -sig or -runs was specified.
+func statistics(p *prof.ProfileItem) string {
+	if !significant && len(p.Runs) < 2 {
+		return ""
+	}
+	lo, hi := p.Interval(confidence)
+	s := fmt.Sprintf(" [%.1f%%-%.1f%%]", lo, hi)
+	if len(p.Runs) > 1 {
+		s += fmt.Sprintf(" ±%.1f%% over %d runs", p.RunStdDev(), len(p.Runs))
+	}
+	return s
+}
 
-						for _, il := range profileInlines {
-							fmt.Printf("%12s(inline) %s:%d\n", tab, il.SourceFile, il.Line)
-						}
-					}
+// reportPlain prints the hotspots in r that have matching diagnostics,
+// followed by those diagnostics, their inline positions and (if -e)
+// their explanations.
+func reportPlain(r *Report) {
+	tab := "        " // Tabs vary, we want 8.
 
-					nearby := ""
-					if int64(d.Range.End.Line) < p.FileLine[0].Line {
-						nearby = "earlier "
-					}
-					if int64(d.Range.Start.Line) > p.FileLine[0].Line {
-						nearby = "later "
-					}
+	if r.Cutoff != "" {
+		fmt.Printf("Hot spots: %s\n", r.Cutoff)
+	}
 
-					// Now it's known if it's nearby or not, start printing....
-					if d.Message != "" { // Note '%5.1f%%, ' is 8 runes wide
-						fmt.Printf("%8s%s, %s (at %sline %d)\n", tab, d.Code, d.Message, nearby, d.Range.Start.Line)
-					} else {
-						fmt.Printf("%8s%s (at %sline %d)\n", tab, d.Code, nearby, d.Range.Start.Line)
-					}
+	for _, h := range r.Hotspots {
+		if len(h.Diagnostics) == 0 {
+			continue
+		}
+		instance := ""
+		if h.Instance != "" {
+			instance = " " + h.Instance
+		}
+		fmt.Printf("%5.1f%%, %s:%d)%s%s%s\n", h.Percent, shorten(h.Position.File), h.Position.Line, statistics(h.item), instance, describeCharged(h.Charged))
+		for _, il := range h.Inlines {
+			fmt.Printf("%12s(inline) %s:%d\n", tab, shorten(il.File), il.Line)
+		}
 
-					diagnosticInlines, remainingRelated := inlinesFromRelated(d.RelatedInformation)
-
-					// Sort through inlines first to determine if this is an exact match or earlier/later/nearby
-					var diagLocs []string
-					inlineNearby := ""
-					for i, j := 0, 0; i < len(profileInlines) || j < len(diagnosticInlines); i, j = i+1, j+1 {
-						// Adjust declarations of "nearness", for inlines insensitive to before/after for now.
-						if inlineNearby == "" && nearby == "" {
-							if i < len(profileInlines) && j < len(diagnosticInlines) {
-								if diagnosticInlines[j].SourceFile != profileInlines[i].SourceFile {
-									inlineNearby = "-nearby" // different files
-								} else if diagnosticInlines[j].LineStart > profileInlines[i].Line {
-									inlineNearby = "-later"
-								} else if diagnosticInlines[j].LineEnd < profileInlines[i].Line {
-									inlineNearby = "-earlier"
-								} else {
-									// still the same
-								}
-							} else {
-								inlineNearby = "-nearby" // mismatched depths
-							}
-						}
-
-						if j < len(diagnosticInlines) {
-							il := diagnosticInlines[j]
-							if il.LineStart == il.LineEnd {
-								diagLocs = append(diagLocs, fmt.Sprintf("(inline%s) %s:%d", inlineNearby, il.SourceFile, il.LineStart))
-							} else {
-								diagLocs = append(diagLocs, fmt.Sprintf("(inline%s) %s:%d-%d", inlineNearby, il.SourceFile, il.LineStart, il.LineEnd))
-
-							}
-							nearby = "not empty" // prevent repeats
-							inlineNearby = ""
-						} else {
-							break // Exit after noticing that the depths are mismatched
-						}
-					}
+		for _, m := range h.Diagnostics {
+			nearby := ""
+			if m.Nearness != exact {
+				nearby = m.Nearness + " "
+			}
+			copies := ""
+			if m.Instances > 1 {
+				copies = fmt.Sprintf(", in %d instantiations", m.Instances)
+			}
+			if m.Charged > 0 {
+				copies += fmt.Sprintf(", %.1f%% charged", m.Charged)
+			}
+			loops := describeLoops(m) // after the position, which tools may parse
+			if m.Message != "" { // Note '%5.1f%%, ' is 8 runes wide
+				fmt.Printf("%8s%s, %s (at %sline %d%s)%s\n", tab, m.Code, m.Message, nearby, m.Position.Line, copies, loops)
+			} else {
+				fmt.Printf("%8s%s (at %sline %d%s)%s\n", tab, m.Code, nearby, m.Position.Line, copies, loops)
+			}
 
-					// Print inline information, as necessary
-					for i := 0; i < len(diagLocs); i++ {
-						fmt.Printf("%16s%s\n", tab, diagLocs[i])
-					}
+			for _, il := range m.Inlines {
+				inlineNearby := ""
+				if il.Nearness != exact {
+					inlineNearby = "-" + il.Nearness
+				}
+				if il.EndLine == 0 {
+					fmt.Printf("%16s(inline%s) %s:%d\n", tab, inlineNearby, shorten(il.File), il.Line)
+				} else {
+					fmt.Printf("%16s(inline%s) %s:%d-%d\n", tab, inlineNearby, shorten(il.File), il.Line, il.EndLine)
+				}
+			}
 
-					// Handle extended "explanations".
-					if explain {
-						// TODO if explanations ever span multiple lines, change this (LineStart -> LineStart...LineEnd)
-						for len(remainingRelated) > 0 {
-							fl := fileLineFromRelated(&remainingRelated[0])
-							fmt.Printf("%12sexplanation :: %s:%d, %s\n", tab, fl.SourceFile, fl.LineStart, remainingRelated[0].Message)
-							diagnosticInlines, remainingRelated = inlinesFromRelated(remainingRelated[1:])
-							for _, fl := range diagnosticInlines {
-								//
-								fmt.Printf("%18s(inline) %s:%d\n", tab, fl.SourceFile, fl.LineStart)
-							}
-						}
+			// Handle extended "explanations".
+			if explain {
+				for _, e := range m.Explanations {
+					fmt.Printf("%12sexplanation :: %s:%d, %s\n", tab, shorten(e.File), e.Line, e.Message)
+					for _, il := range e.Inlines {
+						fmt.Printf("%18s(inline) %s:%d\n", tab, shorten(il.File), il.Line)
 					}
 				}
 			}
+
+			if showHints && m.Hint != "" {
+				fmt.Printf("%12shint: %s\n", tab, m.Hint)
+			}
 		}
 	}
 }
@@ -290,7 +656,7 @@ func fileLineFromRelated(ri *lsp.DiagnosticRelatedInformation) FileLineRange {
 		uri = s
 	}
 	return FileLineRange{
-		SourceFile: shorten(uri),
+		SourceFile: uri,
 		LineStart:  int64(ri.Location.Range.Start.Line),
 		LineEnd:    int64(ri.Location.Range.End.Line),
 	}
@@ -311,10 +677,13 @@ func inlinesFromRelated(relatedInformation []lsp.DiagnosticRelatedInformation) (
 
 // runBench runs a benchmark bench (see global) found in the currrent directory,
 // with appropriate flags to collect both LSP-encoded compiler diagnostics and
-// a cpuprofile.  The returned string contains the names of the diagnostics directory
+// a cpuprofile.  The output of go test is also saved, for -compare.
+// For each -experiment, the benchmark is run again with those compiler flags,
+// saving its profile and output (but not diagnostics) in the returned variants.
+// The returned string contains the names of the diagnostics directory
 // and cpuprofile file, and a cleanup function to remove any temporary directories
 // created here.
-func runBench(testargs []string) (newargs []string, cleanup func()) {
+func runBench(testargs []string) (newargs []string, variants []artifacts, cleanup func()) {
 	testdir, err := os.Getwd()
 	cleanup = func() {}
 	if keep == "" {
@@ -327,21 +696,81 @@ func runBench(testargs []string) (newargs []string, cleanup func()) {
 		keep = "gclsp-bench"
 	}
 	// go test -gcflags=all=-json=0,testdir/gclsp -cpuprofile=testdir/test.prof -bench=Bench .
-	lsp := filepath.Join(testdir, keep+".lspdir")
-	cpuprofile := filepath.Join(testdir, keep+".prof")
+	base := artifactsFor(filepath.Join(testdir, keep))
 
 	if packages != "" {
 		packages = packages + "="
 	}
-	gcFlags := "-gcflags=" + packages + "-json=0," + lsp
+	runTest(base, "-json=0,"+base.lspdir, testargs)
+	if err := completeDiagnostics(base.lspdir, buildFlags()); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not complete diagnostics, %v\n", err)
+	}
+	for i, flags := range experiments {
+		v := artifactsFor(filepath.Join(testdir, fmt.Sprintf("%s.exp%d", keep, i+1)))
+		v.lspdir = ""
+		runTest(v, flags, testargs)
+		variants = append(variants, v)
+	}
+	if pgo != "" && compare == "" && len(experiments) == 0 {
+		compileWithoutPGO(artifactsFor(filepath.Join(testdir, keep+".nopgo")))
+		listDevirtualized(filepath.Join(testdir, keep+".devirt"))
+	}
+	newargs = []string{base.lspdir, base.profile}
+	return
+}
 
-	cmdArgs := []string{"test", gcFlags, "-cpuprofile=" + cpuprofile, "-bench=" + bench, "."}
+// runTest runs go test for runBench, with compiler flags gcflags (for the
+// -packages), writing the profile to a.profile and the output to a.bench.
+func runTest(a artifacts, gcflags string, testargs []string) {
+	profileFlag := "-cpuprofile="
+	if sampleType != "" {
+		profileFlag = "-memprofile="
+	}
+	cmdArgs := []string{"test", "-gcflags=" + packages + gcflags, profileFlag + a.profile, "-bench=" + bench}
+	cmdArgs = append(cmdArgs, buildFlags()...)
+	cmdArgs = append(cmdArgs, ".")
 	cmdArgs = append(cmdArgs, testargs...)
 	cmd := exec.Command("go", cmdArgs...)
 	out := runCmd(cmd)
 	fmt.Printf("%s\n", string(out))
-	newargs = []string{lsp, cpuprofile}
-	return
+	if err := os.WriteFile(a.bench, out, 0666); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not save benchmark output, %v\n", err)
+	}
+}
+
+// compileWithoutPGO compiles the test for runBench without PGO, writing
+// the diagnostics to a.lspdir, to compare with those of the PGO build.
+func compileWithoutPGO(a artifacts) {
+	cmd := exec.Command("go", "test", "-c", "-o", os.DevNull, "-pgo=off", "-gcflags="+packages+"-json=0,"+a.lspdir, ".")
+	runCmd(cmd)
+	if err := completeDiagnostics(a.lspdir, []string{"-pgo=off"}); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not complete diagnostics, %v\n", err)
+	}
+}
+
+// listDevirtualized compiles the test for runBench with PGO again, with -m,
+// and writes the compiler's reports of the calls that PGO devirtualized to
+// file, since the diagnostics do not record them.
+func listDevirtualized(file string) {
+	cmd := exec.Command("go", "test", "-c", "-o", os.DevNull, "-pgo="+pgo, "-gcflags="+packages+"-m", ".")
+	var devirt []string
+	for _, line := range strings.Split(string(runCmd(cmd)), "\n") {
+		if devirtualizedCall.MatchString(line) {
+			devirt = append(devirt, line+"\n")
+		}
+	}
+	if err := os.WriteFile(file, []byte(strings.Join(devirt, "")), 0666); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not save devirtualized calls, %v\n", err)
+	}
+}
+
+// buildFlags returns the flags for go test, other than -gcflags, that
+// affect compilation.
+func buildFlags() []string {
+	if pgo != "" {
+		return []string{"-pgo=" + pgo}
+	}
+	return nil
 }
 
 // runCmd wraps running a command with an error check,
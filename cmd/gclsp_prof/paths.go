@@ -0,0 +1,256 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+	"github.com/dr2chase/gc-lsp-tools/prof"
+)
+
+// pathMapping is a -pathmap=old=new rule; file names in the directory old
+// (or naming old itself) are rewritten to start with new instead.
+type pathMapping struct{ old, new string }
+
+var pathMappings []pathMapping
+
+func parsePathMaps(rules []string) error {
+	for _, r := range rules {
+		i := strings.Index(r, "=")
+		if i <= 0 {
+			return fmt.Errorf("-pathmap=%s, expected old=new", r)
+		}
+		pathMappings = append(pathMappings, pathMapping{r[:i], r[i+1:]})
+	}
+	// Longest old prefix first
+	sort.SliceStable(pathMappings, func(i, j int) bool { return len(pathMappings[i].old) > len(pathMappings[j].old) })
+	return nil
+}
+
+// goEnv is the local go environment, for locating sources named by
+// module-relative (-trimpath) or module cache paths.
+var goEnv struct {
+	loaded              bool
+	goroot, gomodcache  string
+	modulePath, modRoot string // of the main module, containing the current directory
+}
+
+func loadGoEnv() {
+	if goEnv.loaded {
+		return
+	}
+	goEnv.loaded = true
+	if out, err := exec.Command("go", "env", "GOROOT", "GOMODCACHE").Output(); err == nil {
+		lines := strings.Split(string(out), "\n")
+		if len(lines) >= 2 {
+			goEnv.goroot, goEnv.gomodcache = lines[0], lines[1]
+		}
+	}
+	for dir := pwd; dir != "" && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		f, err := os.Open(filepath.Join(dir, "go.mod"))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if fields := strings.Fields(scanner.Text()); len(fields) == 2 && fields[0] == "module" {
+				goEnv.modulePath, goEnv.modRoot = strings.Trim(fields[1], `"`), dir
+				break
+			}
+		}
+		f.Close()
+		break
+	}
+}
+
+func exists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+// escapeModulePath escapes upper case letters as the module cache does, "A" to "!a".
+func escapeModulePath(p string) string {
+	var sb strings.Builder
+	for _, r := range p {
+		if unicode.IsUpper(r) {
+			sb.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// localPath returns the local source file corresponding to file, a file name
+// from a profile or compiler diagnostics, and whether that file exists.
+// In order, it tries the -pathmap rules, the file itself, module cache paths
+// (".../pkg/mod/..." from another machine), and -trimpath paths relative to
+// the main module, the module cache, and GOROOT.
+func localPath(file string) (string, bool) {
+	for _, m := range pathMappings {
+		if hasPathPrefix(file, m.old) {
+			file = m.new + file[len(m.old):]
+			break
+		}
+	}
+	if exists(file) {
+		return file, true
+	}
+	loadGoEnv()
+	var candidates []string
+	if i := strings.Index(file, "/pkg/mod/"); i >= 0 && goEnv.gomodcache != "" {
+		candidates = append(candidates, filepath.Join(goEnv.gomodcache, file[i+len("/pkg/mod/"):]))
+	}
+	if !filepath.IsAbs(file) {
+		if goEnv.modulePath != "" && strings.HasPrefix(file, goEnv.modulePath+"/") {
+			candidates = append(candidates, filepath.Join(goEnv.modRoot, file[len(goEnv.modulePath)+1:]))
+		}
+		if strings.Contains(file, "@") && goEnv.gomodcache != "" {
+			candidates = append(candidates, filepath.Join(goEnv.gomodcache, escapeModulePath(file)))
+		}
+		if goEnv.goroot != "" {
+			candidates = append(candidates, filepath.Join(goEnv.goroot, "src", file))
+		}
+	}
+	for _, c := range candidates {
+		if exists(c) {
+			return c, true
+		}
+	}
+	return file, false
+}
+
+// hasPathPrefix reports whether file is dir or names something in it,
+// so that /build/src is a prefix of /build/src/a.go, but not of /build/srcx/a.go.
+func hasPathPrefix(file, dir string) bool {
+	if !strings.HasPrefix(file, dir) {
+		return false
+	}
+	if len(file) == len(dir) || isSeparator(dir[len(dir)-1]) {
+		return true
+	}
+	return isSeparator(file[len(dir)])
+}
+
+func isSeparator(c byte) bool {
+	return c == '/' || c == filepath.Separator
+}
+
+// localPaths caches the results of localPath.
+var localPaths = make(map[string]struct {
+	file  string
+	found bool
+})
+
+func cachedLocalPath(file string) (string, bool) {
+	if l, ok := localPaths[file]; ok {
+		return l.file, l.found
+	}
+	l, found := localPath(file)
+	localPaths[file] = struct {
+		file  string
+		found bool
+	}{l, found}
+	return l, found
+}
+
+// remapDiagnostics rewrites the keys of byFile, and the locations of the
+// diagnostics' related information (inlined positions and explanations),
+// to local file names (see localPath).
+func remapDiagnostics(byFile map[string]*lsp.CompilerDiagnostics) {
+	var files []string
+	for file := range byFile {
+		files = append(files, file)
+	}
+	for _, file := range files {
+		cd := byFile[file]
+		for _, d := range cd.Diagnostics {
+			for i := range d.RelatedInformation {
+				remapLocation(&d.RelatedInformation[i].Location)
+			}
+		}
+		if l, _ := cachedLocalPath(file); l != file {
+			delete(byFile, file)
+			if old := byFile[l]; old != nil {
+				old.Diagnostics = append(old.Diagnostics, cd.Diagnostics...)
+			} else {
+				byFile[l] = cd
+			}
+		}
+	}
+}
+
+// remapLocation rewrites the file of loc to a local file name (see localPath),
+// in the form the compiler writes, a file URI with an unescaped path.
+func remapLocation(loc *lsp.Location) {
+	uri := string(loc.URI)
+	prefix := ""
+	if strings.HasPrefix(uri, "file://") {
+		prefix = "file://"
+		if s, err := url.PathUnescape(uri[7:]); err == nil {
+			uri = s
+		} else {
+			uri = uri[7:]
+		}
+	}
+	if l, _ := cachedLocalPath(uri); l != uri {
+		loc.URI = lsp.DocumentURI(prefix + l)
+	}
+}
+
+// remapProfile rewrites the file names in pi to local file names (see
+// localPath), and reports, on standard error, the hot profile files that
+// could not be found locally.  If verbose, it also reports the hot profile
+// files that were found but have no diagnostics in byFile.
+func remapProfile(pi []*prof.ProfileItem, byFile map[string]*lsp.CompilerDiagnostics) {
+	found := make(map[string]bool)
+	for _, p := range pi {
+		for i := range p.FileLine {
+			l, ok := cachedLocalPath(p.FileLine[i].SourceFile)
+			p.FileLine[i].SourceFile, found[l] = l, ok
+		}
+	}
+
+	missing := make(map[string]float64)
+	undiagnosed := make(map[string]float64)
+	for _, p := range pi {
+		if !isHot(p) {
+			continue
+		}
+		file := p.FileLine[0].SourceFile
+		if !found[file] && strings.HasSuffix(file, ".go") {
+			missing[file] += p.FlatPercent
+		} else if byFile[file] == nil {
+			undiagnosed[file] += p.FlatPercent
+		}
+	}
+
+	report := func(files map[string]float64, what string) {
+		var names []string
+		for f := range files {
+			names = append(names, f)
+		}
+		sort.Slice(names, func(i, j int) bool { return files[names[i]] > files[names[j]] })
+		fmt.Fprintf(os.Stderr, "%s\n", what)
+		for _, f := range names {
+			fmt.Fprintf(os.Stderr, "%7.1f%%  %s\n", files[f], f)
+		}
+	}
+	if len(missing) > 0 {
+		report(missing, "Hot profile files not found locally (see -pathmap):")
+	}
+	if len(undiagnosed) > 0 && verbose > 0 {
+		report(undiagnosed, "Hot profile files with no diagnostics (see -packages):")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+	"github.com/dr2chase/gc-lsp-tools/prof"
+)
+
+func TestRemapInlinedDiagnostic(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"a.go", "b.go"} {
+		if err := os.WriteFile(filepath.Join(dir, f), []byte("package p\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() {
+		pathMappings, localPaths = nil, make(map[string]struct {
+			file  string
+			found bool
+		})
+	}()
+	if err := parsePathMaps([]string{"/build/src=" + dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	related := func(message, file string, line uint) lsp.DiagnosticRelatedInformation {
+		var ri lsp.DiagnosticRelatedInformation
+		ri.Message = message
+		ri.Location.URI = lsp.DocumentURI("file://" + file)
+		ri.Location.Range.Start.Line, ri.Location.Range.End.Line = line, line
+		return ri
+	}
+	d := &lsp.Diagnostic{Code: "escape", Message: "x escapes to heap"}
+	d.Range.Start.Line, d.Range.End.Line = 12, 12
+	d.RelatedInformation = []lsp.DiagnosticRelatedInformation{
+		related("inlineLoc", "/build/src/b.go", 7),
+		related("escflow:    flow: ~r0 = x:", "/build/src/b.go", 8),
+	}
+	byFile := map[string]*lsp.CompilerDiagnostics{"/build/src/a.go": {Diagnostics: []*lsp.Diagnostic{d}}}
+	remapDiagnostics(byFile)
+
+	a, b := filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")
+	if byFile[a] == nil || len(byFile) != 1 {
+		t.Fatalf("diagnostics not remapped to %s: %v", a, byFile)
+	}
+	for i, ri := range d.RelatedInformation {
+		if got, want := string(ri.Location.URI), "file://"+b; got != want {
+			t.Errorf("related information %d: URI %s, want %s", i, got, want)
+		}
+	}
+
+	// The profile's files, remapped the same way, match the inlined position exactly.
+	m := matchDiagnostic(d, prof.FileLine{SourceFile: a, Line: 12}, []prof.FileLine{{SourceFile: b, Line: 7}})
+	if len(m.Inlines) != 1 || m.Inlines[0].Nearness != exact || m.Inlines[0].Position.File != b {
+		t.Errorf("inlines %+v, want exactly %s:7", m.Inlines, b)
+	}
+	if len(m.Explanations) != 1 || m.Explanations[0].Position.File != b {
+		t.Errorf("explanations %+v, want one at %s:8", m.Explanations, b)
+	}
+	if file, line, _ := innermostPosition(m); file != b || line != 7 {
+		t.Errorf("innermost position %s:%d, want %s:7", file, line, b)
+	}
+}
+
+func TestPathMapSibling(t *testing.T) {
+	dir := t.TempDir()
+	defer func() { pathMappings = nil }()
+	if err := parsePathMaps([]string{"/build/src=" + dir}); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"/build/src/a.go":     filepath.Join(dir, "a.go"),
+		"/build/src/p/b.go":   filepath.Join(dir, "p/b.go"),
+		"/build/src":          dir,
+		"/build/srcx/a.go":    "/build/srcx/a.go", // a sibling that shares the prefix
+		"/build/src.old/a.go": "/build/src.old/a.go",
+		"/elsewhere/src/a.go": "/elsewhere/src/a.go",
+	}
+	for file, want := range tests {
+		if got, _ := localPath(file); got != want {
+			t.Errorf("localPath(%s) = %s, want %s", file, got, want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+	"github.com/dr2chase/gc-lsp-tools/prof"
+)
+
+// interfaceCall reports whether a call on line of file to the method named
+// by the profile's function name callee is a call of an interface method.
+func interfaceCall(file string, line int64, callee string) bool {
+	tp := typeCheck(file)
+	if tp == nil {
+		return false
+	}
+	for _, call := range nodesOnLine[*ast.CallExpr](tp.files[file], int(line)) {
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || !names(callee, sel.Sel.Name) {
+			continue
+		}
+		if t := tp.info.TypeOf(sel.X); t != nil && types.IsInterface(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// devirtualizedCall matches the compiler's -m report of a call that PGO
+// devirtualized, e.g. "./a.go:22:14: PGO devirtualizing interface call s.Area to (*Big).Area".
+var devirtualizedCall = regexp.MustCompile(`^(.+?):(\d+):\d+: PGO devirtualizing (?:interface|function) call \S+ to (\S+)$`)
+
+// readDevirtualized reads the compiler's reports of PGO devirtualization in
+// file (see listDevirtualized), and returns the callee that each devirtualized
+// call now calls directly, by position.  File names relative to the current
+// directory, where the test was compiled, are made absolute.
+func readDevirtualized(file string) (map[fileLine]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	devirt := make(map[fileLine]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m := devirtualizedCall.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		name := m[1]
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(pwd, name)
+		}
+		name, _ = cachedLocalPath(name)
+		line, _ := strconv.ParseInt(m[2], 10, 64)
+		devirt[fileLine{name, line}] = m[3]
+	}
+	return devirt, scanner.Err()
+}
+
+// pgoCallChange describes what PGO changed about the call c, whose callee is
+// callee (if known), given the calls devirt that PGO devirtualized (see
+// readDevirtualized), the diagnostics noPGO without PGO, and the inlinability
+// inl of functions without PGO.  It returns "" if PGO changed nothing known.
+func pgoCallChange(c *prof.CallItem, callee *prof.FuncItem, devirt map[fileLine]string, noPGO map[string]*lsp.CompilerDiagnostics, inl map[fileLine]*lsp.Diagnostic) string {
+	to, ok := devirt[fileLine{c.Caller.SourceFile, c.Caller.Line}]
+	devirtualized := ok && names(c.Callee, to)
+	if !c.Inlined {
+		if devirtualized {
+			return "devirtualized, not inlined"
+		}
+		return ""
+	}
+	if devirtualized || interfaceCall(c.Caller.SourceFile, c.Caller.Line, c.Callee) {
+		return "devirtualized and inlined"
+	}
+	if d := cannotInlineCall(noPGO[c.Caller.SourceFile], c.Caller.Line); d != nil {
+		return "inlined; without PGO, " + d.Message
+	}
+	if callee != nil {
+		if d := inl[fileLine{callee.SourceFile, callee.StartLine}]; d != nil && d.Code == "cannotInlineFunction" {
+			return "inlined; without PGO, " + d.Message
+		}
+	}
+	return "" // inlined either way, or not known
+}
+
+// cannotInlineCall returns the cannotInlineCall diagnostic in cd at line, if any.
+func cannotInlineCall(cd *lsp.CompilerDiagnostics, line int64) *lsp.Diagnostic {
+	if cd == nil {
+		return nil
+	}
+	for _, d := range cd.Diagnostics {
+		if d.Code == "cannotInlineCall" && int64(d.Range.Start.Line) == line {
+			return d
+		}
+	}
+	return nil
+}
+
+// sameDiagnostics reports whether hot spots a and b, of the same profile
+// item, have the same diagnostics.
+func sameDiagnostics(a, b *Hotspot) bool {
+	if len(a.Diagnostics) != len(b.Diagnostics) {
+		return false
+	}
+	var as, bs []string
+	for i := range a.Diagnostics {
+		as = append(as, identity(a.Diagnostics[i].diagnostic))
+		bs = append(bs, identity(b.Diagnostics[i].diagnostic))
+	}
+	sort.Strings(as)
+	sort.Strings(bs)
+	return strings.Join(as, "\n") == strings.Join(bs, "\n")
+}
+
+// reportPGO prints what building with PGO changed at the hot spots of the
+// profile of the run in with, which was built with PGO, compared with the
+// diagnostics of the same packages built without PGO in withoutLspdir:
+// the hot functions whose inlinability or inlining cost changed,
+// the hot calls that were inlined only with PGO, because the callee was
+// too complex without it or because PGO devirtualized an interface call,
+// and those that PGO devirtualized (as listed in devirtFile) but did not
+// inline, the hot escapes that appeared or disappeared, and the hot lines
+// whose diagnostics did not change at all.
+func reportPGO(with artifacts, withoutLspdir, devirtFile string) error {
+	pi, byFile, err := readRun(with)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", with.profile, err)
+	}
+	noPGO, _, err := readDiagnostics(withoutLspdir, []string{with.profile})
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", withoutLspdir, err)
+	}
+	if err := chooseThreshold(pi); err != nil {
+		return err
+	}
+	if cutoff != "" {
+		fmt.Printf("Hot spots: %s\n", cutoff)
+	}
+
+	opts := prof.Options{Binary: binary, SampleType: sampleType, Verbose: int(verbose)}
+	funcs, calls, err := prof.Cumulative([]string{with.profile}, opts)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]*prof.FuncItem)
+	for _, f := range funcs {
+		f.SourceFile, _ = cachedLocalPath(f.SourceFile)
+		byName[f.Name] = f
+	}
+	devirt, err := readDevirtualized(devirtFile)
+	if err != nil {
+		return err
+	}
+	inl, inlPGO := inlineDiagnostics(noPGO), inlineDiagnostics(byFile)
+
+	fmt.Printf("Hot functions whose inlinability or cost changed with PGO, by cumulative samples:\n")
+	found := false
+	for _, f := range funcs {
+		k := fileLine{f.SourceFile, f.StartLine}
+		d, dPGO := inl[k], inlPGO[k]
+		if f.CumPercent < threshold || d == nil || dPGO == nil || d.Message == dPGO.Message {
+			continue
+		}
+		found = true
+		fmt.Printf("%5.1f%%, %s %s:%d, without PGO: %s; with PGO: %s\n", f.CumPercent, f.Name, shorten(f.SourceFile), f.StartLine, d.Message, dPGO.Message)
+	}
+	if !found {
+		fmt.Printf("%8s(none)\n", "")
+	}
+
+	fmt.Printf("Hot calls inlined or devirtualized only with PGO, by cumulative samples:\n")
+	found = false
+	for _, c := range calls {
+		if c.CumPercent < threshold {
+			continue
+		}
+		c.Caller.SourceFile, _ = cachedLocalPath(c.Caller.SourceFile)
+		if byFile[c.Caller.SourceFile] == nil {
+			continue
+		}
+		change := pgoCallChange(c, byName[c.Callee], devirt, noPGO, inl)
+		if change == "" {
+			continue
+		}
+		found = true
+		fmt.Printf("%5.1f%%, %s:%d) %s calls %s: %s\n", c.CumPercent, shorten(c.Caller.SourceFile), c.Caller.Line, c.CallerName, c.Callee, change)
+	}
+	if !found {
+		fmt.Printf("%8s(none)\n", "")
+	}
+
+	withR, withoutR := buildReport(pi, byFile), buildReport(pi, noPGO)
+	escapes := func(r *Report) []*ranked {
+		var result []*ranked
+		for _, rk := range hotDiagnostics(r) {
+			if allocationCodes.MatchString(rk.match.Code) {
+				result = append(result, rk)
+			}
+		}
+		return result
+	}
+	withEsc, withoutEsc := escapes(withR), escapes(withoutR)
+	for _, s := range []struct {
+		title string
+		rs    []*ranked
+	}{{"Hot escapes that PGO removed:", unmatched(withoutEsc, withEsc)},
+		{"Hot escapes that appeared with PGO:", unmatched(withEsc, withoutEsc)}} {
+		fmt.Println(s.title)
+		if len(s.rs) == 0 {
+			fmt.Printf("%8s(none)\n", "")
+		}
+		for _, rk := range s.rs {
+			m := rk.match
+			fmt.Printf("%6.1f%%  %s, %s at %s:%d\n", rk.percent, m.Code, m.Message, shorten(m.Position.File), m.Position.Line)
+		}
+	}
+
+	fmt.Println("Hot lines where PGO made no difference to the diagnostics:")
+	found = false
+	none := 0 // hot lines with no diagnostics either way
+	for i := len(withR.Hotspots) - 1; i >= 0; i-- {
+		h := withR.Hotspots[i]
+		if byFile[h.Position.File] == nil || !sameDiagnostics(h, withoutR.Hotspots[i]) {
+			continue
+		}
+		if len(h.Diagnostics) == 0 {
+			none++
+			continue
+		}
+		found = true
+		var codes []string
+		for _, m := range h.Diagnostics {
+			codes = append(codes, m.Code)
+		}
+		fmt.Printf("%6.1f%%  %s:%d, %s\n", h.Percent, shorten(h.Position.File), h.Position.Line, strings.Join(codes, ", "))
+	}
+	if !found {
+		fmt.Printf("%8s(none)\n", "")
+	}
+	if none > 0 {
+		fmt.Printf("%8s(and %d hot lines with no diagnostics either way)\n", "", none)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dr2chase/gc-lsp-tools/prof"
+)
+
+const pgoSource = `package p
+
+type I interface{ M() int }
+
+type T struct{ n int }
+
+func (t *T) M() int {
+	defer func() {}()
+	return t.n
+}
+
+type U struct{}
+
+func (U) M() int { return 1 }
+
+func call(i I) int {
+	return i.M()
+}
+
+func other(i I) int {
+	return i.M()
+}
+`
+
+func TestPGOCallChange(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.go")
+	if err := os.WriteFile(a, []byte(pgoSource), 0666); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { pwd = old }(pwd)
+	pwd = dir
+
+	// As go test -c -gcflags=-m prints them, among other things.
+	devirtFile := filepath.Join(dir, "run.devirt")
+	m := "./a.go:17:10: PGO devirtualizing interface call i.M to (*T).M\n" +
+		"./a.go:7:6: cannot inline (*T).M: unhandled op DEFER\n" +
+		"./b.go:3:4: PGO devirtualizing function call f to g\n"
+	if err := os.WriteFile(devirtFile, []byte(m), 0666); err != nil {
+		t.Fatal(err)
+	}
+	devirt, err := readDevirtualized(devirtFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := filepath.Join(dir, "b.go")
+	if len(devirt) != 2 || devirt[fileLine{a, 17}] != "(*T).M" || devirt[fileLine{b, 3}] != "g" {
+		t.Errorf("readDevirtualized = %v, want (*T).M at %s:17 and g at %s:3", devirt, a, b)
+	}
+
+	tests := []struct {
+		line    int64
+		callee  string
+		inlined bool
+		want    string
+	}{
+		{17, "example.com/p.(*T).M", false, "devirtualized, not inlined"},
+		// The other types still go through the interface.
+		{17, "example.com/p.U.M", false, ""},
+		// An interface call that PGO did not devirtualize.
+		{21, "example.com/p.(*T).M", false, ""},
+		{21, "example.com/p.U.M", true, "devirtualized and inlined"},
+	}
+	for _, tc := range tests {
+		c := &prof.CallItem{Caller: prof.FileLine{SourceFile: a, Line: tc.line}, Callee: tc.callee, Inlined: tc.inlined}
+		if got := pgoCallChange(c, nil, devirt, nil, nil); got != tc.want {
+			t.Errorf("line %d, %s, inlined %v: %q, want %q", tc.line, tc.callee, tc.inlined, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+	"github.com/dr2chase/gc-lsp-tools/prof"
+)
+
+// reportVersion is the version of the JSON report schema.
+// It changes whenever a field is removed or its meaning changes;
+// new fields may be added without changing the version.
+const reportVersion = 1
+
+// Report is the result of matching hot spots in a profile against compiler
+// diagnostics.  It is rendered as text by reportPlain, and written as JSON by
+// writeJSON.  File names are not abbreviated.
+type Report struct {
+	Version    int        `json:"version"`
+	Threshold  float64    `json:"threshold"`             // percentage, see -t
+	Cutoff     string     `json:"cutoff,omitempty"`      // how the threshold was chosen, if by -top or -cover
+	SampleType string     `json:"sample_type,omitempty"` // of Hotspot.Samples, if not cpu samples, e.g. alloc_space, see -alloc
+	Before     int64      `json:"before"`                // lines, see -b
+	After      int64      `json:"after"`                 // lines, see -a
+	Confidence float64    `json:"confidence,omitempty"`  // if intervals were computed, see -conf
+	Hotspots   []*Hotspot `json:"hotspots"`              // in increasing order of Percent
+	Stale      []*Stale   `json:"stale,omitempty"`       // see -stale
+	Hidden     []*Hidden  `json:"hidden,omitempty"`      // the hot spots not shown, by scope, see -scope
+}
+
+// Position is a file and line, or range of lines.
+type Position struct {
+	File    string `json:"file"`
+	Line    int64  `json:"line"`
+	EndLine int64  `json:"end_line,omitempty"` // if different from Line
+}
+
+// Hotspot is a profile location at or above the threshold,
+// and the diagnostics that were found near it.
+type Hotspot struct {
+	Percent     float64            `json:"percent"`
+	Samples     float64            `json:"samples"`
+	Interval    []float64          `json:"interval,omitempty"` // low and high percentage, if computed
+	Runs        []float64          `json:"runs,omitempty"`     // per-run percentages, see -runs
+	Position    Position           `json:"position"`           // outermost
+	Inlines     []Position         `json:"inlines,omitempty"`  // the inlined positions within Position, outermost first
+	Instance    string             `json:"instance,omitempty"` // the innermost generic function instantiation, see -instances
+	Charged     map[string]float64 `json:"charged,omitempty"`  // the part of Percent charged from each runtime function, see -charge
+	Diagnostics []*Match           `json:"diagnostics"`
+
+	item *prof.ProfileItem
+}
+
+// Nearness classifications, for the position of a diagnostic relative to
+// a hotspot, and for the first inlined position of an otherwise exact match.
+const (
+	exact   = "exact"
+	earlier = "earlier"
+	later   = "later"
+	nearby  = "nearby" // for inlines, a different file, or a different inlining depth
+)
+
+// Match is a diagnostic found near a hotspot.
+type Match struct {
+	Code         string         `json:"code"`
+	Message      string         `json:"message,omitempty"`
+	Position     Position       `json:"position"` // outermost
+	Nearness     string         `json:"nearness"`
+	Inlines      []InlineMatch  `json:"inlines,omitempty"`
+	Explanations []*Explanation `json:"explanations,omitempty"`
+	Cost         float64        `json:"cost"`                 // estimated cost per execution, see -costs
+	Payoff       float64        `json:"payoff"`               // hotspot percent times cost, times loopWeight for checks
+	LoopDepth    int            `json:"loop_depth,omitempty"` // for bounds and nil checks, the number of loops around the check
+	Induction    string         `json:"induction,omitempty"`  // for bounds checks, the loop induction variable in the index, if any
+	Hint         string         `json:"hint,omitempty"`
+	Instances    int            `json:"instances,omitempty"` // with -instances, the number of instantiations with this diagnostic, if more than one
+	Charged      float64        `json:"charged,omitempty"`   // with -charge, the part of the hotspot's Percent charged from runtime functions doing this diagnostic's work
+
+	hint *hint
+
+	diagnostic *lsp.Diagnostic
+}
+
+// InlineMatch is an inlined position of a diagnostic,
+// classified by its nearness to the corresponding hotspot inline.
+type InlineMatch struct {
+	Position
+	Nearness string `json:"nearness"`
+}
+
+// Explanation is one step of an extended explanation, e.g., of an escape.
+type Explanation struct {
+	Position
+	Message string     `json:"message"`
+	Inlines []Position `json:"inlines,omitempty"`
+}
+
+// Stale is a //gclsp:ignore suppression that matched no diagnostic.
+type Stale struct {
+	Position
+	Code   string `json:"code"`
+	Reason string `json:"reason,omitempty"`
+	Scope  string `json:"scope"`
+}
+
+func staleSuppressions(suppressions *lsp.Suppressions) []*Stale {
+	var result []*Stale
+	for _, s := range suppressions.Stale() {
+		result = append(result, &Stale{Position{File: s.File, Line: int64(s.Line)}, s.Code, s.Reason, s.Scope()})
+	}
+	return result
+}
+
+func (f FileLineRange) position() Position {
+	p := Position{File: f.SourceFile, Line: f.LineStart}
+	if f.LineEnd != f.LineStart {
+		p.EndLine = f.LineEnd
+	}
+	return p
+}
+
+// buildReport matches the hot profile items in pi against the diagnostics in byFile.
+func buildReport(pi []*prof.ProfileItem, byFile map[string]*lsp.CompilerDiagnostics) *Report {
+	r := &Report{Version: reportVersion, Threshold: threshold, Cutoff: cutoff, SampleType: sampleType, Before: before, After: after, Hotspots: []*Hotspot{}}
+	if significant {
+		r.Confidence = confidence
+	}
+
+	near := func(d *lsp.Diagnostic, line int64) bool {
+		diagStart := int64(d.Range.Start.Line)
+		diagEnd := int64(d.Range.End.Line)
+		return line-before <= diagStart && diagEnd <= line+after
+	}
+
+	hidden := make(map[string]*Hidden)
+	defer func() {
+		for _, sc := range scopeNames {
+			if h := hidden[sc]; h != nil {
+				r.Hidden = append(r.Hidden, h)
+			}
+		}
+	}()
+
+	for _, p := range pi {
+		if !isHot(p) {
+			continue
+		}
+		fl := p.FileLine[0]
+		if sc, ok := inScope(fl.SourceFile, byFile); !ok {
+			if hidden[sc] == nil {
+				hidden[sc] = &Hidden{Scope: sc}
+			}
+			hidden[sc].Hotspots++
+			hidden[sc].Percent += p.FlatPercent
+			continue
+		}
+		profileInlines := p.FileLine[1:]
+		h := &Hotspot{
+			Percent:     p.FlatPercent,
+			Samples:     p.FlatTotal,
+			Runs:        p.Runs,
+			Position:    Position{File: fl.SourceFile, Line: fl.Line},
+			Charged:     chargedPercents(p),
+			Diagnostics: []*Match{},
+			item:        p,
+		}
+		if significant {
+			lo, hi := p.Interval(confidence)
+			h.Interval = []float64{lo, hi}
+		}
+		for _, il := range profileInlines {
+			h.Inlines = append(h.Inlines, Position{File: il.SourceFile, Line: il.Line})
+		}
+		for _, fl := range p.FileLine {
+			if fl.Instance != "" {
+				h.Instance = fl.Instance
+			}
+		}
+		r.Hotspots = append(r.Hotspots, h)
+
+		cd := byFile[fl.SourceFile]
+		if cd == nil {
+			continue
+		}
+		copies := make(map[string]*Match) // for -instances, identical diagnostics from different instantiations
+		for _, d := range cd.Diagnostics {
+			if d.Code == "inlineCall" { // Don't want to see these, they are confusing and eventually removed..
+				continue
+			}
+			if filterRE != nil && !filterRE.MatchString(d.Code) {
+				continue
+			}
+			if !near(d, fl.Line) {
+				continue
+			}
+			if instances {
+				if !shapesMatch(d, h.Instance) {
+					continue
+				}
+				k := identity(d)
+				if m := copies[k]; m != nil {
+					m.Instances++
+					continue
+				}
+			}
+			m := matchDiagnostic(d, fl, profileInlines)
+			if instances {
+				m.Instances = 1
+				copies[identity(d)] = m
+			}
+			if funcs := chargedFuncsFor(m.Code); funcs != nil {
+				m.Charged = p.ChargedPercent(funcs)
+			}
+			if isCheck(m.Code) {
+				m.LoopDepth, m.Induction = loopFacts(m)
+			}
+			m.Cost = estimatedCost(m)
+			m.Payoff = h.Percent * m.Cost * loopWeight(m)
+			if showHints || hintDiff != "" {
+				if m.hint = hintFor(m); m.hint != nil {
+					m.Hint = m.hint.text
+				}
+			}
+			h.Diagnostics = append(h.Diagnostics, m)
+		}
+	}
+	return r
+}
+
+// shape matches the shape types that the compiler mentions in messages about instantiated code.
+var shape = regexp.MustCompile(`go\.shape\.[\w.*]+`)
+
+// shapesMatch reports whether the shape types mentioned by d, if any,
+// all appear in the name of the instantiation instance.
+func shapesMatch(d *lsp.Diagnostic, instance string) bool {
+	mentioned := shape.FindAllString(d.Message, -1)
+	for _, ri := range d.RelatedInformation {
+		mentioned = append(mentioned, shape.FindAllString(ri.Message, -1)...)
+	}
+	for _, s := range mentioned {
+		if !strings.Contains(instance, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// identity returns a key that is the same for identical diagnostics, as
+// are produced at the same position for different instantiations.
+func identity(d *lsp.Diagnostic) string {
+	return fmt.Sprintf("%s %q %v %v", d.Code, d.Message, d.Range, d.RelatedInformation)
+}
+
+// matchDiagnostic classifies diagnostic d relative to the hotspot at fl,
+// with inlines profileInlines.
+func matchDiagnostic(d *lsp.Diagnostic, fl prof.FileLine, profileInlines []prof.FileLine) *Match {
+	m := &Match{
+		Code:       d.Code,
+		Message:    d.Message,
+		Position:   FileLineRange{fl.SourceFile, int64(d.Range.Start.Line), int64(d.Range.End.Line)}.position(),
+		Nearness:   exact,
+		diagnostic: d,
+	}
+	if int64(d.Range.End.Line) < fl.Line {
+		m.Nearness = earlier
+	}
+	if int64(d.Range.Start.Line) > fl.Line {
+		m.Nearness = later
+	}
+
+	diagnosticInlines, remainingRelated := inlinesFromRelated(d.RelatedInformation)
+
+	// Only the first inline of an otherwise exact match is classified;
+	// after that, or for an inexact match, inlines are reported as-is.
+	for j, il := range diagnosticInlines {
+		inlineNearby := exact
+		if j == 0 && m.Nearness == exact {
+			if len(profileInlines) > 0 {
+				if il.SourceFile != profileInlines[0].SourceFile {
+					inlineNearby = nearby // different files
+				} else if il.LineStart > profileInlines[0].Line {
+					inlineNearby = later
+				} else if il.LineEnd < profileInlines[0].Line {
+					inlineNearby = earlier
+				}
+			} else {
+				inlineNearby = nearby // mismatched depths
+			}
+		}
+		m.Inlines = append(m.Inlines, InlineMatch{Position: il.position(), Nearness: inlineNearby})
+	}
+
+	// TODO if explanations ever span multiple lines, change this (LineStart -> LineStart...LineEnd)
+	for len(remainingRelated) > 0 {
+		e := &Explanation{
+			Position: Position{File: fileLineFromRelated(&remainingRelated[0]).SourceFile, Line: int64(remainingRelated[0].Location.Range.Start.Line)},
+			Message:  remainingRelated[0].Message,
+		}
+		diagnosticInlines, remainingRelated = inlinesFromRelated(remainingRelated[1:])
+		for _, fl := range diagnosticInlines {
+			e.Inlines = append(e.Inlines, Position{File: fl.SourceFile, Line: fl.LineStart})
+		}
+		m.Explanations = append(m.Explanations, e)
+	}
+	return m
+}
+
+// writeJSON writes the report to file, or to standard output if file is "-".
+func writeJSON(r *Report, file string) error {
+	out := os.Stdout
+	if file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
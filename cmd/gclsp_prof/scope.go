@@ -0,0 +1,150 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+)
+
+// The scopes of source files, for -scope.
+const (
+	scopeModule = "module" // in a main module
+	scopeDeps   = "deps"   // in a dependency, e.g., in the module cache or vendored
+	scopeGoroot = "goroot" // in the standard library or runtime
+)
+
+var scopeNames = []string{scopeModule, scopeDeps, scopeGoroot}
+
+// shownScopes are the scopes of the hot spots reported, nil for all of them.
+var shownScopes map[string]bool
+
+// parseScope sets shownScopes from the -scope flag s, a comma-separated
+// list of scopes, or "all".
+func parseScope(s string) error {
+	if s == "" || s == "all" {
+		return nil
+	}
+	shownScopes = make(map[string]bool)
+	for _, sc := range strings.Split(s, ",") {
+		sc = strings.TrimSpace(sc)
+		known := false
+		for _, n := range scopeNames {
+			known = known || sc == n
+		}
+		if !known {
+			return fmt.Errorf("-scope=%s, expected all or a comma-separated list of %s", s, strings.Join(scopeNames, ", "))
+		}
+		shownScopes[sc] = true
+	}
+	return nil
+}
+
+// mainModules are the module paths and directories of the main modules
+// (several, in a workspace), from go list -m.
+var mainModules []struct{ path, dir string }
+
+var mainModulesLoaded bool
+
+func loadMainModules() {
+	if mainModulesLoaded {
+		return
+	}
+	mainModulesLoaded = true
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Path}}\t{{.Dir}}").Output()
+	if err == nil {
+		for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			if fields := strings.Split(line, "\t"); len(fields) == 2 && fields[1] != "" {
+				mainModules = append(mainModules, struct{ path, dir string }{fields[0], fields[1]})
+			}
+		}
+	}
+	if len(mainModules) == 0 {
+		loadGoEnv()
+		if goEnv.modRoot != "" {
+			mainModules = append(mainModules, struct{ path, dir string }{goEnv.modulePath, goEnv.modRoot})
+		}
+	}
+}
+
+// within reports whether file is in directory dir.
+func within(file, dir string) bool {
+	return dir != "" && strings.HasPrefix(file, dir+string(filepath.Separator))
+}
+
+// fileScope returns the scope of file, a local path if one was found (see
+// localPath), whose diagnostics, if any, are cd.  Files in GOROOT and the
+// main modules are recognized by directory, except for vendored files; the
+// package path in the diagnostics decides for files elsewhere, and if there
+// are none, the file is taken to be from a dependency.
+func fileScope(file string, cd *lsp.CompilerDiagnostics) string {
+	loadGoEnv()
+	loadMainModules()
+	if within(file, filepath.Join(goEnv.goroot, "src")) {
+		return scopeGoroot
+	}
+	for _, m := range mainModules {
+		if within(file, m.dir) {
+			if strings.Contains(file[len(m.dir):], string(filepath.Separator)+"vendor"+string(filepath.Separator)) {
+				return scopeDeps
+			}
+			return scopeModule
+		}
+	}
+	if cd == nil || cd.Header == nil {
+		return scopeDeps
+	}
+	pkg := cd.Header.Package
+	for _, m := range mainModules {
+		if pkg == m.path || strings.HasPrefix(pkg, m.path+"/") {
+			return scopeModule
+		}
+	}
+	if first := strings.Split(pkg, "/")[0]; pkg != "main" && !strings.Contains(first, ".") {
+		return scopeGoroot
+	}
+	return scopeDeps
+}
+
+// fileScopes caches the results of fileScope.
+var fileScopes = make(map[string]string)
+
+// inScope returns the scope of file, whose diagnostics are in byFile,
+// and whether it is one of the -scope (if there is no -scope, it is,
+// and the scope is not determined).
+func inScope(file string, byFile map[string]*lsp.CompilerDiagnostics) (string, bool) {
+	if shownScopes == nil {
+		return "", true
+	}
+	sc, ok := fileScopes[file]
+	if !ok {
+		sc = fileScope(file, byFile[file])
+		fileScopes[file] = sc
+	}
+	return sc, shownScopes[sc]
+}
+
+// Hidden is the weight of the hot spots of one scope that -scope did not show.
+type Hidden struct {
+	Scope    string  `json:"scope"`
+	Hotspots int     `json:"hotspots"`
+	Percent  float64 `json:"percent"`
+}
+
+// reportHidden prints a summary of the hot spots that -scope did not show.
+func reportHidden(r *Report) {
+	if len(r.Hidden) == 0 {
+		return
+	}
+	var parts []string
+	for _, h := range r.Hidden {
+		parts = append(parts, fmt.Sprintf("%d hot spots, %.1f%%, in %s", h.Hotspots, h.Percent, h.Scope))
+	}
+	fmt.Printf("Not shown (see -scope): %s\n", strings.Join(parts, "; "))
+}
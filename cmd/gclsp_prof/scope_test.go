@@ -0,0 +1,42 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+)
+
+func TestFileScope(t *testing.T) {
+	loadGoEnv()
+	mainModulesLoaded = true
+	mainModules = []struct{ path, dir string }{{"example.com/m", "/work/m"}}
+	defer func() { mainModulesLoaded, mainModules = false, nil }()
+
+	diags := func(pkg string) *lsp.CompilerDiagnostics {
+		return &lsp.CompilerDiagnostics{Header: &lsp.VersionHeader{Package: pkg}}
+	}
+	tests := []struct {
+		file string
+		cd   *lsp.CompilerDiagnostics
+		want string
+	}{
+		{filepath.Join(goEnv.goroot, "src", "runtime", "malloc.go"), nil, scopeGoroot},
+		{"/work/m/sub/x.go", nil, scopeModule},
+		{"/work/m/vendor/example.org/d/y.go", nil, scopeDeps},
+		{"/home/u/go/pkg/mod/example.org/d@v1.0.0/y.go", nil, scopeDeps},
+		// Not found locally, decided by package path
+		{"example.com/m/sub/x.go", diags("example.com/m/sub"), scopeModule},
+		{"runtime/malloc.go", diags("runtime"), scopeGoroot},
+		{"example.org/d@v1.0.0/y.go", diags("example.org/d"), scopeDeps},
+	}
+	for _, tc := range tests {
+		if got := fileScope(tc.file, tc.cd); got != tc.want {
+			t.Errorf("fileScope(%q) = %s, want %s", tc.file, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+)
+
+// parsedFile is a source file, parsed, for examining the context of diagnostics.
+type parsedFile struct {
+	name  string
+	fset  *token.FileSet
+	file  *ast.File
+	lines []string // 0-indexed, without newlines
+}
+
+var parsedFiles = make(map[string]*parsedFile)
+
+// parseSource returns the parsed source file name, or nil if it cannot be read
+// or parsed.  Results are cached.
+func parseSource(name string) *parsedFile {
+	if pf, ok := parsedFiles[name]; ok {
+		return pf
+	}
+	var pf *parsedFile
+	if src, err := os.ReadFile(name); err == nil {
+		fset := token.NewFileSet()
+		if f, err := parser.ParseFile(fset, name, src, parser.ParseComments); err == nil {
+			pf = &parsedFile{name: name, fset: fset, file: f, lines: strings.Split(string(src), "\n")}
+		}
+	}
+	parsedFiles[name] = pf
+	return pf
+}
+
+// line returns the 1-based line n of the source, or "".
+func (pf *parsedFile) line(n int) string {
+	if n < 1 || n > len(pf.lines) {
+		return ""
+	}
+	return pf.lines[n-1]
+}
+
+// indent returns the leading white space of line n.
+func (pf *parsedFile) indent(n int) string {
+	l := pf.line(n)
+	return l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+}
+
+// pathTo returns the nodes that enclose line:col, outermost first.
+// If col is zero, the first non-blank column of the line is used.
+func (pf *parsedFile) pathTo(line, col int) []ast.Node {
+	tf := pf.fset.File(pf.file.Pos())
+	if line < 1 || line > tf.LineCount() {
+		return nil
+	}
+	if col <= 0 {
+		col = len(pf.indent(line)) + 1
+	}
+	pos := tf.LineStart(line) + token.Pos(col-1)
+	var path []ast.Node
+	ast.Inspect(pf.file, func(n ast.Node) bool {
+		if n == nil || pos < n.Pos() || n.End() <= pos {
+			return false
+		}
+		path = append(path, n)
+		return true
+	})
+	return path
+}
+
+// nodesOnLine returns the nodes of type T that start on line, in source order.
+func nodesOnLine[T ast.Node](pf *parsedFile, line int) []T {
+	var result []T
+	ast.Inspect(pf.file, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		start, end := pf.fset.Position(n.Pos()).Line, pf.fset.Position(n.End()).Line
+		if end < line || start > line {
+			return false
+		}
+		if t, ok := n.(T); ok && start == line {
+			result = append(result, t)
+		}
+		return true
+	})
+	return result
+}
+
+// enclosingLoops returns the for and range statements in path, outermost first,
+// stopping at any function literal (loops outside it do not repeat its body).
+func enclosingLoops(path []ast.Node) []ast.Stmt {
+	var loops []ast.Stmt
+	for _, n := range path {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			loops = loops[:0]
+		case *ast.ForStmt:
+			loops = append(loops, n)
+		case *ast.RangeStmt:
+			loops = append(loops, n)
+		}
+	}
+	return loops
+}
+
+// enclosingFunc returns the innermost function declaration or literal in path, or nil.
+func enclosingFunc(path []ast.Node) ast.Node {
+	for i := len(path) - 1; i >= 0; i-- {
+		switch n := path[i].(type) {
+		case *ast.FuncDecl, *ast.FuncLit:
+			return n
+		}
+	}
+	return nil
+}
+
+// funcName returns the name of the function declaration or literal fn,
+// including the receiver type of a method (e.g. "(*T).M"); a function
+// literal is named for its enclosing declaration, e.g. "F.func".
+func (pf *parsedFile) funcName(fn ast.Node) string {
+	switch fn := fn.(type) {
+	case *ast.FuncDecl:
+		if fn.Recv == nil || len(fn.Recv.List) == 0 {
+			return fn.Name.Name
+		}
+		return lsp.RecvString(fn.Recv.List[0].Type) + "." + fn.Name.Name
+	case *ast.FuncLit:
+		for _, d := range pf.file.Decls {
+			if d, ok := d.(*ast.FuncDecl); ok && d.Pos() <= fn.Pos() && fn.End() <= d.End() {
+				return pf.funcName(d) + ".func"
+			}
+		}
+		return "func"
+	}
+	return ""
+}
+
+// relative returns file relative to the current directory,
+// if it is within it, and otherwise file itself.
+func relative(file string) string {
+	if rel, err := filepath.Rel(pwd, file); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return file
+}
+
+// exprString returns the source form of e.
+func (pf *parsedFile) exprString(e ast.Expr) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, pf.fset, e)
+	return buf.String()
+}
+
+// lineOf returns the line number of n.
+func (pf *parsedFile) lineOf(n ast.Node) int {
+	return pf.fset.Position(n.Pos()).Line
+}
+
+// innermostPosition returns the file, line and column at which the diagnostic
+// of m actually occurs, which is its innermost inlined position if it has one.
+func innermostPosition(m *Match) (file string, line, col int) {
+	d := m.diagnostic
+	file, line, col = m.Position.File, int(d.Range.Start.Line), int(d.Range.Start.Character)
+	inlines, _ := inlinesFromRelated(d.RelatedInformation)
+	if len(inlines) > 0 {
+		ri := d.RelatedInformation[len(inlines)-1]
+		il := inlines[len(inlines)-1]
+		file, line, col = il.SourceFile, int(il.LineStart), int(ri.Location.Range.Start.Character)
+	}
+	return
+}
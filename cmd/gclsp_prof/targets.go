@@ -0,0 +1,187 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+	"github.com/dr2chase/gc-lsp-tools/prof"
+)
+
+// selectedTarget is the target whose diagnostics are matched against the profile.
+var selectedTarget lsp.Target
+
+// otherTargets holds the diagnostics for the targets in the lspdir
+// other than the one selected for the profile, for -arch-diff.
+var otherTargets map[lsp.Target]map[string]*lsp.CompilerDiagnostics
+
+// parseTarget parses a -target flag, goos/goarch.
+func parseTarget(s string) (lsp.Target, error) {
+	i := strings.Index(s, "/")
+	if i <= 0 || i == len(s)-1 {
+		return lsp.Target{}, fmt.Errorf("-target=%s, expected goos/goarch", s)
+	}
+	return lsp.Target{Goos: s[:i], Goarch: s[i+1:]}, nil
+}
+
+// profiledTarget returns the target of the binary profiled in profiles,
+// if the binary is available (see -binary), and has the build ID that
+// the profile records.
+func profiledTarget(profiles []string) (lsp.Target, bool) {
+	for _, p := range profiles {
+		pbi, err := prof.ProfileBuildInfo(p)
+		if err != nil {
+			continue
+		}
+		bin := binary
+		if bin == "" {
+			bin = pbi.Binary
+		}
+		bbi, err := prof.BinaryBuildInfo(bin)
+		if err != nil || bbi.Goarch == "" || pbi.BuildID != "" && bbi.BuildID != "" && pbi.BuildID != bbi.BuildID {
+			continue
+		}
+		return lsp.Target{Goos: bbi.Goos, Goarch: bbi.Goarch}, true
+	}
+	return lsp.Target{}, false
+}
+
+// selectTarget chooses, from the targets in byTarget, the one to match
+// against profiles: the one specified by -target, or else the profiled
+// binary's, or else the only one.  Failing those, it chooses the host's,
+// or the one with the most files, with a warning.  It also sets otherTargets.
+func selectTarget(byTarget map[lsp.Target]map[string]*lsp.CompilerDiagnostics, profiles []string) (lsp.Target, error) {
+	var targets []lsp.Target
+	for t := range byTarget {
+		targets = append(targets, t)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].String() < targets[j].String() })
+
+	var chosen lsp.Target
+	switch {
+	case target != "":
+		t, err := parseTarget(target)
+		if err != nil {
+			return t, err
+		}
+		if byTarget[t] == nil {
+			return t, fmt.Errorf("no diagnostics for -target=%s, only for %v", t, targets)
+		}
+		chosen = t
+	case len(targets) <= 1:
+		if len(targets) == 1 {
+			chosen = targets[0]
+		}
+	default:
+		if t, ok := profiledTarget(profiles); ok && byTarget[t] != nil {
+			chosen = t
+			break
+		}
+		host := lsp.Target{Goos: runtime.GOOS, Goarch: runtime.GOARCH}
+		if byTarget[host] != nil {
+			chosen = host
+		} else {
+			for _, t := range targets {
+				if len(byTarget[t]) > len(byTarget[chosen]) {
+					chosen = t
+				}
+			}
+		}
+		fmt.Fprintf(os.Stderr, "warning: diagnostics are for %v and the profiled binary's target is not known; using %s (see -target)\n", targets, chosen)
+	}
+
+	selectedTarget = chosen
+	otherTargets = make(map[lsp.Target]map[string]*lsp.CompilerDiagnostics)
+	for t, byFile := range byTarget {
+		if t != chosen {
+			otherTargets[t] = byFile
+		}
+	}
+	if verbose > 0 && len(targets) > 1 {
+		fmt.Fprintf(os.Stderr, "Using diagnostics for %s, of %v\n", chosen, targets)
+	}
+	return chosen, nil
+}
+
+// reportArchDiffs prints, for each hot spot of r, the diagnostics near it
+// that are present for some targets but not others.
+func reportArchDiffs(r *Report) {
+	if len(otherTargets) == 0 {
+		return
+	}
+	type key struct {
+		code, message string
+		line          int64
+	}
+	keys := func(byFile map[string]*lsp.CompilerDiagnostics, h *Hotspot) map[key]int {
+		result := make(map[key]int)
+		cd := byFile[h.Position.File]
+		if cd == nil {
+			return result
+		}
+		for _, d := range cd.Diagnostics {
+			if d.Code == "inlineCall" || filterRE != nil && !filterRE.MatchString(d.Code) {
+				continue
+			}
+			if h.Position.Line-before <= int64(d.Range.Start.Line) && int64(d.Range.End.Line) <= h.Position.Line+after {
+				result[key{d.Code, d.Message, int64(d.Range.Start.Line)}]++
+			}
+		}
+		return result
+	}
+
+	var others []lsp.Target
+	for t := range otherTargets {
+		others = append(others, t)
+	}
+	sort.Slice(others, func(i, j int) bool { return others[i].String() < others[j].String() })
+
+	fmt.Printf("Hot diagnostics for %s that differ for %v:\n", selectedTarget, others)
+	differ := false
+	for _, h := range r.Hotspots {
+		selected := make(map[key]int)
+		for _, m := range h.Diagnostics {
+			selected[key{m.Code, m.Message, m.Position.Line}]++
+		}
+		var lines []string
+		for _, t := range others {
+			other := keys(otherTargets[t], h)
+			for k, n := range selected {
+				if other[k] < n {
+					lines = append(lines, fmt.Sprintf("%8snot for %s: %s (at line %d)", "", t, describeKey(k.code, k.message), k.line))
+				}
+			}
+			for k, n := range other {
+				if selected[k] < n {
+					lines = append(lines, fmt.Sprintf("%8sonly for %s: %s (at line %d)", "", t, describeKey(k.code, k.message), k.line))
+				}
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		sort.Strings(lines)
+		differ = true
+		fmt.Printf("%5.1f%%, %s:%d)\n", h.Percent, shorten(h.Position.File), h.Position.Line)
+		for _, l := range lines {
+			fmt.Println(l)
+		}
+	}
+	if !differ {
+		fmt.Printf("%8s(none)\n", "")
+	}
+}
+
+func describeKey(code, message string) string {
+	if message == "" {
+		return code
+	}
+	return code + ", " + message
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/dr2chase/gc-lsp-tools/prof"
+)
+
+func TestChooseThreshold(t *testing.T) {
+	savedTop, savedCover, savedThreshold := top, cover, threshold
+	defer func() { top, cover, threshold, cutoff = savedTop, savedCover, savedThreshold, "" }()
+
+	items := func(pcts ...float64) []*prof.ProfileItem {
+		var pi []*prof.ProfileItem
+		for _, p := range pcts {
+			pi = append(pi, &prof.ProfileItem{FlatPercent: p})
+		}
+		return pi
+	}
+	tests := []struct {
+		top       int
+		cover     string
+		pi        []*prof.ProfileItem
+		threshold float64
+		cutoff    string
+		err       bool
+	}{
+		{top: 2, pi: items(5, 40, 20, 10), threshold: 20, cutoff: "top 2 profile entries, threshold 20.00%"},
+		{top: 2, pi: items(20, 40, 20, 20, 5), threshold: 20, cutoff: "top 2 profile entries (4 with ties), threshold 20.00%"},
+		{top: 10, pi: items(30, 10), threshold: 10, cutoff: "top 2 profile entries, threshold 10.00%"},
+		{top: 3, pi: nil, threshold: 1, cutoff: ""},
+		{top: -1, pi: items(1), threshold: 1, err: true},
+		{cover: "50", pi: items(10, 40, 30, 20), threshold: 30, cutoff: "hottest 2 profile entries, covering 70.0% of samples, threshold 30.00%"},
+		{cover: "70%", pi: items(10, 40, 30, 20), threshold: 30, cutoff: "hottest 2 profile entries, covering 70.0% of samples, threshold 30.00%"},
+		{cover: "50", pi: items(25, 25, 25, 25), threshold: 25, cutoff: "hottest 2 profile entries (4 with ties), covering 50.0% of samples, threshold 25.00%"},
+		{cover: "100", pi: items(60, 30), threshold: 30, cutoff: "hottest 2 profile entries, covering 90.0% of samples, threshold 30.00%"},
+		{cover: "50", pi: nil, threshold: 1, cutoff: ""},
+		{cover: "150", pi: items(1), threshold: 1, err: true},
+		{cover: "x", pi: nil, threshold: 1, err: true},
+		{top: 1, cover: "50", pi: items(1), threshold: 1, err: true},
+	}
+	for _, tc := range tests {
+		top, cover, threshold, cutoff = tc.top, tc.cover, 1, ""
+		err := chooseThreshold(tc.pi)
+		if (err != nil) != tc.err {
+			t.Errorf("-top=%d -cover=%q: error %v, want error %v", tc.top, tc.cover, err, tc.err)
+			continue
+		}
+		if threshold != tc.threshold || cutoff != tc.cutoff {
+			t.Errorf("-top=%d -cover=%q %d entries: threshold %g, %q; want %g, %q", tc.top, tc.cover, len(tc.pi), threshold, cutoff, tc.threshold, tc.cutoff)
+		}
+	}
+}
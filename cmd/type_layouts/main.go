@@ -42,6 +42,7 @@ var memprofile = ""
 var threshold = 1.0
 var filter = ""
 var filterRE *regexp.Regexp
+var stale = false
 
 // count is a flag.Value that is like a flag.Bool and a flag.Int.
 // If used as -name, it increments the count, but -name=x sets the count.
@@ -88,6 +89,7 @@ func main() {
 	flag.Float64Var(&threshold, "t", threshold, "Threshold percentage below which types will be ignored")
 	flag.StringVar(&shortenEVs, "s", shortenEVs, "Environment variables used to abbreviate file names in output")
 
+	flag.BoolVar(&stale, "stale", stale, "Also report //gclsp:ignore suppressions that no longer match any diagnostic")
 	flag.StringVar(&cpuprofile, "cpuprofile", cpuprofile, "Record a cpu profile in this file")
 	flag.StringVar(&memprofile, "memprofile", memprofile, "Record a mem profile in this file")
 
@@ -165,9 +167,20 @@ If profiles are present, they are used to weight the type-associated sizes by al
 	if err != nil {
 		panic(err)
 	}
+	suppressions := lsp.ReadSuppressions(byFile)
+	n := suppressions.Filter(byFile)
+	if verbose > 0 {
+		fmt.Fprintf(os.Stderr, "%d diagnostics suppressed by %d //gclsp:ignore directives\n", n, len(suppressions.All()))
+	}
 
 	reportPlain(byFile, pi, int(verbose))
 
+	if stale {
+		for _, s := range suppressions.Stale() {
+			fmt.Printf("Stale suppression %s:%d, %s (%s) %s\n", shorten(s.File), s.Line, s.Code, s.Scope(), s.Reason)
+		}
+	}
+
 }
 
 type void struct{}
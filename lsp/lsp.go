@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 //  head -1 logopt/%00/x.json
@@ -25,6 +26,20 @@ type VersionHeader struct {
 	File      string `json:"file,omitempty"` // LSP requires an enclosing resource, i.e., a file
 }
 
+// Target is the operating system and architecture that diagnostics were compiled for.
+type Target struct {
+	Goos, Goarch string
+}
+
+func (t Target) String() string {
+	return t.Goos + "/" + t.Goarch
+}
+
+// Target returns the target of the compilation that produced h.
+func (h *VersionHeader) Target() Target {
+	return Target{h.Goos, h.Goarch}
+}
+
 // DocumentURI, Position, Range, Location, Diagnostic, DiagnosticRelatedInformation all reuse json definitions from gopls.
 // See https://github.com/golang/tools/blob/22afafe3322a860fcd3d88448768f9db36f8bc5f/internal/lsp/protocol/tsprotocol.go
 
@@ -140,6 +155,7 @@ type Diagnostic struct {
 type CompilerDiagnostics struct {
 	Header      *VersionHeader
 	Diagnostics []*Diagnostic
+	ModTime     time.Time // of the file the diagnostics were read from, if known
 }
 
 // ReadFile converts the json-encoded contents of a file (reader)
@@ -196,6 +212,7 @@ func ReadPackage(dir string, verbose int) (cds []*CompilerDiagnostics, err error
 			if err != nil {
 				return err
 			}
+			cd.ModTime = info.ModTime()
 			cds = append(cds, cd)
 		}
 		return err
@@ -207,7 +224,28 @@ func ReadPackage(dir string, verbose int) (cds []*CompilerDiagnostics, err error
 // a package, and populates a map from (outermost) source file to compiler diagnostics
 // for that file.
 // Indexing is by outermost file for a diagnostic's position.
+// Diagnostics for all targets are merged; see ReadAllTargets to keep them apart.
 func ReadAll(dir string, byFile map[string]*CompilerDiagnostics, verbose int) error {
+	return readAll(dir, func(Target) map[string]*CompilerDiagnostics { return byFile }, verbose)
+}
+
+// ReadAllTargets is ReadAll, except that diagnostics for different targets
+// (e.g., from compiling for both linux/amd64 and linux/arm64 into the same
+// directory) are kept apart, in separate maps indexed by target.
+func ReadAllTargets(dir string, verbose int) (map[Target]map[string]*CompilerDiagnostics, error) {
+	byTarget := make(map[Target]map[string]*CompilerDiagnostics)
+	err := readAll(dir, func(t Target) map[string]*CompilerDiagnostics {
+		if byTarget[t] == nil {
+			byTarget[t] = make(map[string]*CompilerDiagnostics)
+		}
+		return byTarget[t]
+	}, verbose)
+	return byTarget, err
+}
+
+// readAll reads the package directories in dir, adding each file's
+// diagnostics to the map that byFile returns for their target.
+func readAll(dir string, byFile func(Target) map[string]*CompilerDiagnostics, verbose int) error {
 	first := true
 	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -225,13 +263,14 @@ func ReadAll(dir string, byFile map[string]*CompilerDiagnostics, verbose int) er
 		}
 		cds, err := ReadPackage(path, verbose)
 		for _, cd := range cds {
-			if old, ok := byFile[cd.Header.File]; ok {
+			m := byFile(cd.Header.Target())
+			if old, ok := m[cd.Header.File]; ok {
 				old.Diagnostics = append(old.Diagnostics, cd.Diagnostics...)
 				if verbose > 2 {
 					fmt.Fprintf(os.Stderr, "Appending %s from %s to data for %s\n", cd.Header.File, cd.Header.Package, old.Header.Package)
 				}
 			} else {
-				byFile[cd.Header.File] = cd
+				m[cd.Header.File] = cd
 			}
 		}
 		return filepath.SkipDir
@@ -0,0 +1,280 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lsp
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Suppression directives, written in source as
+//
+//	//gclsp:ignore code [reason]
+//	//gclsp:ignore-inlined code [reason]
+//
+// A directive at the end of a line applies to that line; a directive alone on
+// a line applies to the next line; a directive in a function's doc comment (or
+// at the end of its func line) applies to the whole function.
+// The code may end in "*" to match as a prefix, so "*" matches every code.
+//
+// An ignore directive suppresses diagnostics whose innermost position (where
+// the code actually is, after inlining) is within the lines it covers.  An
+// ignore-inlined directive also suppresses diagnostics in code that was
+// inlined into those lines, i.e., those with any position of their inlining
+// chain within them.
+const (
+	ignoreDirective        = "//gclsp:ignore"
+	ignoreInlinedDirective = "//gclsp:ignore-inlined"
+)
+
+// Suppression is one suppression directive.
+type Suppression struct {
+	File       string // source file
+	Line       int    // of the directive itself
+	Start, End int    // lines covered
+	Code       string
+	Reason     string
+	Inlined    bool   // also suppresses diagnostics in inlined callees
+	Func       string // if the directive applies to a function, its name
+	Used       int    // number of diagnostics suppressed
+}
+
+// Scope describes the extent of the suppression, "line" or "function",
+// and whether it includes inlined callees.
+func (s *Suppression) Scope() string {
+	scope := "line"
+	if s.Func != "" {
+		scope = "function " + s.Func
+	}
+	if s.Inlined {
+		scope += " and inlined callees"
+	}
+	return scope
+}
+
+func (s *Suppression) matches(code string) bool {
+	if strings.HasSuffix(s.Code, "*") {
+		return strings.HasPrefix(code, s.Code[:len(s.Code)-1])
+	}
+	return s.Code == code
+}
+
+// Suppressions are the suppression directives of a set of source files.
+type Suppressions struct {
+	byFile map[string][]*Suppression
+	list   []*Suppression // in file and line order
+}
+
+// ReadSuppressions reads the suppression directives in the source files that
+// the diagnostics in byFile (see ReadAll) refer to, including the files of
+// inlined positions.  Files that cannot be read or parsed are ignored.
+func ReadSuppressions(byFile map[string]*CompilerDiagnostics) *Suppressions {
+	files := make(map[string]bool)
+	for file, cd := range byFile {
+		files[file] = true
+		for _, d := range cd.Diagnostics {
+			for _, p := range d.positions(file) {
+				files[p.file] = true
+			}
+		}
+	}
+	s := &Suppressions{byFile: make(map[string][]*Suppression)}
+	for file := range files {
+		ss := parseSuppressions(file)
+		if len(ss) > 0 {
+			s.byFile[file] = ss
+			s.list = append(s.list, ss...)
+		}
+	}
+	sort.Slice(s.list, func(i, j int) bool {
+		if s.list[i].File != s.list[j].File {
+			return s.list[i].File < s.list[j].File
+		}
+		return s.list[i].Line < s.list[j].Line
+	})
+	return s
+}
+
+// parseSuppressions returns the suppression directives in file.
+func parseSuppressions(file string) []*Suppression {
+	src, err := os.ReadFile(file)
+	if err != nil || !strings.Contains(string(src), ignoreDirective) {
+		return nil
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, src, parser.ParseComments)
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(string(src), "\n")
+	line := func(p token.Pos) int { return fset.Position(p).Line }
+
+	// Functions, by the lines of their doc comments and func keyword
+	funcs := make(map[int]*ast.FuncDecl)
+	for _, decl := range f.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		funcs[line(fd.Pos())] = fd
+		if fd.Doc != nil {
+			for l := line(fd.Doc.Pos()); l <= line(fd.Doc.End()); l++ {
+				funcs[l] = fd
+			}
+		}
+	}
+
+	var result []*Suppression
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			s := parseDirective(c.Text)
+			if s == nil {
+				continue
+			}
+			s.File = file
+			s.Line = line(c.Pos())
+			alone := strings.TrimSpace(lines[s.Line-1][:fset.Position(c.Pos()).Column-1]) == ""
+			if fd := funcs[s.Line]; fd != nil && (alone || s.Line == line(fd.Pos())) {
+				s.Func = fd.Name.Name
+				if fd.Recv != nil && len(fd.Recv.List) == 1 {
+					s.Func = RecvString(fd.Recv.List[0].Type) + "." + s.Func
+				}
+				s.Start, s.End = line(fd.Pos()), line(fd.End())
+			} else if alone {
+				// The next line that is not a comment
+				s.Start = s.Line + 1
+				for s.Start <= len(lines) && strings.HasPrefix(strings.TrimSpace(lines[s.Start-1]), "//") {
+					s.Start++
+				}
+				s.End = s.Start
+			} else {
+				s.Start, s.End = s.Line, s.Line
+			}
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// parseDirective returns the suppression in comment text, or nil if there is none.
+func parseDirective(text string) *Suppression {
+	s := &Suppression{}
+	switch {
+	case strings.HasPrefix(text, ignoreInlinedDirective+" "):
+		s.Inlined = true
+		text = text[len(ignoreInlinedDirective):]
+	case strings.HasPrefix(text, ignoreDirective+" "):
+		text = text[len(ignoreDirective):]
+	default:
+		return nil
+	}
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil
+	}
+	s.Code = fields[0]
+	s.Reason = strings.Join(fields[1:], " ")
+	return s
+}
+
+// RecvString returns the receiver type e of a method as it appears in the
+// method's name in profiles and compiler diagnostics, e.g. "(*T)" for
+// *T[P], so that "RecvString(e).M" names the method.
+func RecvString(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.StarExpr:
+		return "(*" + RecvString(e.X) + ")"
+	case *ast.IndexExpr:
+		return RecvString(e.X)
+	case *ast.IndexListExpr:
+		return RecvString(e.X)
+	case *ast.Ident:
+		return e.Name
+	}
+	return "?"
+}
+
+// position is a file and line of a diagnostic or one of its inlined positions.
+type position struct {
+	file string
+	line int
+}
+
+// positions returns the position of d, whose outermost file is file,
+// followed by its inlined positions, outermost first.
+func (d *Diagnostic) positions(file string) []position {
+	ps := []position{{file, int(d.Range.Start.Line)}}
+	for _, ri := range d.RelatedInformation {
+		if ri.Message != "inlineLoc" {
+			break
+		}
+		uri := string(ri.Location.URI)
+		if strings.HasPrefix(uri, "file://") {
+			if s, err := url.PathUnescape(uri[7:]); err == nil {
+				uri = s
+			} else {
+				uri = uri[7:]
+			}
+		}
+		ps = append(ps, position{uri, int(ri.Location.Range.Start.Line)})
+	}
+	return ps
+}
+
+// Suppressed returns the suppression that applies to diagnostic d, whose
+// outermost file is file, or nil if there is none.
+func (s *Suppressions) Suppressed(file string, d *Diagnostic) *Suppression {
+	ps := d.positions(file)
+	for i, p := range ps {
+		innermost := i == len(ps)-1
+		for _, sp := range s.byFile[p.file] {
+			if (innermost || sp.Inlined) && sp.Start <= p.line && p.line <= sp.End && sp.matches(d.Code) {
+				return sp
+			}
+		}
+	}
+	return nil
+}
+
+// Filter removes the suppressed diagnostics from byFile, counting the uses
+// of each suppression, and returns the number removed.
+func (s *Suppressions) Filter(byFile map[string]*CompilerDiagnostics) int {
+	n := 0
+	for file, cd := range byFile {
+		kept := cd.Diagnostics[:0]
+		for _, d := range cd.Diagnostics {
+			if sp := s.Suppressed(file, d); sp != nil {
+				sp.Used++
+				n++
+				continue
+			}
+			kept = append(kept, d)
+		}
+		cd.Diagnostics = kept
+	}
+	return n
+}
+
+// All returns all the suppressions, in file and line order.
+func (s *Suppressions) All() []*Suppression {
+	return s.list
+}
+
+// Stale returns the suppressions that did not match any diagnostic
+// in calls to Filter, in file and line order.
+func (s *Suppressions) Stale() []*Suppression {
+	var stale []*Suppression
+	for _, sp := range s.list {
+		if sp.Used == 0 {
+			stale = append(stale, sp)
+		}
+	}
+	return stale
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lsp_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dr2chase/gc-lsp-tools/lsp"
+)
+
+const suppressSrc = `package p
+
+func get(a []int, i int) int {
+	return a[i]
+}
+
+// sum adds.
+//gclsp:ignore nilcheck the pointer is never nil
+func sum(p *[]int) (s int) {
+	for i := range *p {
+		s += get(*p, i) //gclsp:ignore-inlined isInBounds checked by the caller
+	}
+	//gclsp:ignore isSliceInBounds
+	_ = (*p)[1:]
+	return
+}
+
+func other(a []int) int {
+	return a[0] //gclsp:ignore isInBounds stale
+}
+`
+
+func diagnostic(code string, line uint, inlines ...lsp.DiagnosticRelatedInformation) *lsp.Diagnostic {
+	d := &lsp.Diagnostic{Code: code, RelatedInformation: inlines}
+	d.Range.Start.Line, d.Range.End.Line = line, line
+	return d
+}
+
+func inlineLoc(file string, line uint) lsp.DiagnosticRelatedInformation {
+	ri := lsp.DiagnosticRelatedInformation{Message: "inlineLoc"}
+	ri.Location.URI = lsp.DocumentURI("file://" + file)
+	ri.Location.Range.Start.Line, ri.Location.Range.End.Line = line, line
+	return ri
+}
+
+func TestSuppressions(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "p.go")
+	if err := os.WriteFile(file, []byte(suppressSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		d          *lsp.Diagnostic
+		suppressed bool
+	}{
+		{diagnostic("isInBounds", 11, inlineLoc(file, 4)), true},  // inlined into an ignore-inlined line
+		{diagnostic("isInBounds", 4), false},                      // the callee itself, not inlined
+		{diagnostic("nilcheck", 10), true},                        // anywhere in sum
+		{diagnostic("nilcheck", 4), false},                        // not in sum
+		{diagnostic("isSliceInBounds", 14), true},                 // the line after the directive
+		{diagnostic("isSliceInBounds", 13), false},                // the directive's own line
+		{diagnostic("nilcheck", 11, inlineLoc(file, 4)), false},   // inlined from outside sum, so not in it
+		{diagnostic("isInBounds", 20, inlineLoc(file, 11)), true}, // innermost position is the exact line
+	}
+	cd := &lsp.CompilerDiagnostics{Header: &lsp.VersionHeader{File: file}}
+	for _, tc := range tests {
+		cd.Diagnostics = append(cd.Diagnostics, tc.d)
+	}
+	byFile := map[string]*lsp.CompilerDiagnostics{file: cd}
+
+	s := lsp.ReadSuppressions(byFile)
+	if got := len(s.All()); got != 4 {
+		t.Fatalf("read %d suppressions, want 4", got)
+	}
+	for i, tc := range tests {
+		if got := s.Suppressed(file, tc.d) != nil; got != tc.suppressed {
+			t.Errorf("%d: %s at line %d suppressed=%v, want %v", i, tc.d.Code, tc.d.Range.Start.Line, got, tc.suppressed)
+		}
+	}
+
+	if n := s.Filter(byFile); n != 4 {
+		t.Errorf("Filter removed %d diagnostics, want 4", n)
+	}
+	stale := s.Stale()
+	if len(stale) != 1 || stale[0].Line != 19 || stale[0].Reason != "stale" {
+		t.Errorf("Stale() = %+v, want the suppression at line 19", stale)
+	}
+	if got, want := s.All()[0].Scope(), "function sum"; got != want {
+		t.Errorf("Scope() = %q, want %q", got, want)
+	}
+}
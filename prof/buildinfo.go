@@ -0,0 +1,95 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prof
+
+import (
+	"debug/buildinfo"
+	"debug/elf"
+	"encoding/hex"
+	"os"
+	"regexp"
+
+	"github.com/google/pprof/profile"
+)
+
+// BuildInfo is what is known about the build of a (profiled) program.
+// Any field may be empty, if that is not known.
+type BuildInfo struct {
+	Binary    string // the main binary
+	BuildID   string // as recorded in profile mappings, i.e., the GNU build ID if there is one
+	GoVersion string // e.g. "go1.21.3" or "devel go1.22-86487adf6a Thu Nov 7 ..."
+	Goos      string
+	Goarch    string
+}
+
+var goVersionComment = regexp.MustCompile(`\b(go1\.[0-9]+(\.[0-9]+|rc[0-9]+|beta[0-9]+)?|devel \S+)`)
+
+// ProfileBuildInfo returns what the profile in file says about the build of
+// the profiled program: the file and build ID of its main (first) mapping,
+// and a Go version, if one of the profile's comments mentions one.
+func ProfileBuildInfo(file string) (*BuildInfo, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil {
+		return nil, err
+	}
+	bi := &BuildInfo{}
+	if len(p.Mapping) > 0 {
+		bi.Binary, bi.BuildID = p.Mapping[0].File, p.Mapping[0].BuildID
+	}
+	for _, c := range p.Comments {
+		if v := goVersionComment.FindString(c); v != "" {
+			bi.GoVersion = v
+			break
+		}
+	}
+	return bi, nil
+}
+
+// BinaryBuildInfo returns the build information of the Go binary in file.
+func BinaryBuildInfo(file string) (*BuildInfo, error) {
+	info, err := buildinfo.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	bi := &BuildInfo{Binary: file, GoVersion: info.GoVersion}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "GOOS":
+			bi.Goos = s.Value
+		case "GOARCH":
+			bi.Goarch = s.Value
+		}
+	}
+	if f, err := elf.Open(file); err == nil {
+		bi.BuildID = gnuBuildID(f)
+		f.Close()
+	}
+	return bi, nil
+}
+
+// gnuBuildID returns the GNU build ID note of an ELF file, in hex,
+// which is how the Go runtime records it in profile mappings, or "" if none.
+func gnuBuildID(f *elf.File) string {
+	sect := f.Section(".note.gnu.build-id")
+	if sect == nil {
+		return ""
+	}
+	data, err := sect.Data()
+	if err != nil || len(data) < 16 {
+		return ""
+	}
+	nameSize := f.ByteOrder.Uint32(data[0:])
+	descSize := f.ByteOrder.Uint32(data[4:])
+	start := 12 + (nameSize+3)&^3
+	if uint64(start)+uint64(descSize) > uint64(len(data)) {
+		return ""
+	}
+	return hex.EncodeToString(data[start : start+descSize])
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prof
+
+import (
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// Runtime functions that do work on behalf of the code that calls them,
+// grouped by the kind of work.  A trailing "*" matches any suffix.
+var (
+	AllocationFuncs = []string{"runtime.mallocgc*", "runtime.newobject", "runtime.newarray", "runtime.makeslice*",
+		"runtime.growslice", "runtime.makemap*", "runtime.convT*", "runtime.concatstring*", "runtime.rawstring*",
+		"runtime.slicebytetostring", "runtime.stringtoslicebyte", "runtime.slicerunetostring", "runtime.stringtoslicerune"}
+	BoundsCheckFuncs = []string{"runtime.panicIndex*", "runtime.panicSlice*", "runtime.goPanicIndex*", "runtime.goPanicSlice*"}
+	CopyFuncs        = []string{"runtime.gcWriteBarrier*", "runtime.wbBufFlush*", "runtime.wbMove", "runtime.bulkBarrierPreWrite",
+		"runtime.typedmemmove", "runtime.typedslicecopy", "runtime.memmove", "runtime.memclrNoHeapPointers"}
+)
+
+// DefaultCharged is the default for Options.Charge: allocation, bounds
+// check failures, write barriers and copying.
+var DefaultCharged = append(append(append([]string(nil), AllocationFuncs...), BoundsCheckFuncs...), CopyFuncs...)
+
+// runtimePackages are the prefixes of function names in the runtime proper,
+// which are walked past while looking for the caller of a charged function.
+var runtimePackages = []string{"runtime.", "runtime/internal/", "internal/runtime/", "internal/bytealg."}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(name, p[:len(p)-1]) {
+				return true
+			}
+		} else if name == p {
+			return true
+		}
+	}
+	return false
+}
+
+func isRuntime(name string) bool {
+	for _, p := range runtimePackages {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// ChargedFrame finds the frame of sample s that the sample should be
+// charged to, if s is in a function matching charge (see DefaultCharged),
+// or in the runtime on behalf of one.  Starting at the leaf, it walks past
+// runtime frames to the first frame outside the runtime, and returns the
+// indices of that frame's Location in s and of its Line in that Location,
+// and the outermost matching function, i.e., the one that user code called.
+// If no function matches, or there is no frame outside the runtime,
+// from is "".
+func ChargedFrame(s *profile.Sample, charge []string) (loc, line int, from string) {
+	for i, l := range s.Location {
+		for j, ln := range l.Line {
+			if ln.Function == nil {
+				return 0, 0, ""
+			}
+			name := ln.Function.Name
+			if matchesAny(name, charge) {
+				from = name
+				continue
+			}
+			if isRuntime(name) {
+				continue
+			}
+			if from == "" {
+				return 0, 0, ""
+			}
+			return i, j, from
+		}
+	}
+	return 0, 0, ""
+}
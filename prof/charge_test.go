@@ -0,0 +1,55 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prof_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dr2chase/gc-lsp-tools/prof"
+	"github.com/google/pprof/profile"
+)
+
+// stack returns a sample whose locations are the given frames, leaf first;
+// frames within one location (inlined) are separated by "|", innermost first.
+func stack(frames ...string) *profile.Sample {
+	s := &profile.Sample{}
+	for _, f := range frames {
+		loc := &profile.Location{}
+		for _, name := range strings.Split(f, "|") {
+			loc.Line = append(loc.Line, profile.Line{Function: &profile.Function{Name: name}})
+		}
+		s.Location = append(s.Location, loc)
+	}
+	return s
+}
+
+func TestChargedFrame(t *testing.T) {
+	tests := []struct {
+		sample    *profile.Sample
+		loc, line int
+		from      string
+	}{
+		// In the allocator, called by user code
+		{stack("runtime.nextFreeFast", "runtime.mallocgc", "runtime.newobject", "main.build", "main.main"), 3, 0, "runtime.newobject"},
+		// GC assist, during allocation
+		{stack("runtime.scanObject", "runtime.gcAssistAlloc", "runtime.mallocgcSmallScanNoHeaderSC5", "main.build"), 3, 0, "runtime.mallocgcSmallScanNoHeaderSC5"},
+		// A runtime function that allocates, called by user code inlined into another
+		{stack("runtime.mallocgc", "runtime.makemap_small", "main.inlined|main.caller"), 2, 0, "runtime.makemap_small"},
+		// A write barrier, from a leaf inlined into its caller
+		{stack("runtime.gcWriteBarrier2", "main.set|main.caller"), 1, 0, "runtime.gcWriteBarrier2"},
+		// User code
+		{stack("main.build", "main.main"), 0, 0, ""},
+		// Runtime work not on behalf of user code
+		{stack("runtime.scanObject", "runtime.gcDrain", "runtime.gcBgMarkWorker"), 0, 0, ""},
+		{stack("runtime.futex", "runtime.notesleep", "main.main"), 0, 0, ""},
+	}
+	for i, test := range tests {
+		loc, line, from := prof.ChargedFrame(test.sample, prof.DefaultCharged)
+		if loc != test.loc || line != test.line || from != test.from {
+			t.Errorf("%d: ChargedFrame = %d, %d, %q, want %d, %d, %q", i, loc, line, from, test.loc, test.line, test.from)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prof
+
+import (
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// FuncItem is the cumulative weight of a function: that of
+// the samples with the function anywhere on their stacks.
+type FuncItem struct {
+	Name       string
+	SourceFile string
+	StartLine  int64 // of the function's declaration, 0 if not known
+	CumPercent float64
+	CumTotal   float64
+}
+
+// CallItem is the cumulative weight of the calls from one line to one
+// function: that of the samples with that call on their stacks.  For the
+// calls of a function that is inlined in several places, the weights of
+// all those places are combined.
+type CallItem struct {
+	Caller     FileLine // the line of the call
+	CallerName string   // the function containing the call, perhaps itself inlined
+	Callee     string
+	Inlined    bool
+	CumPercent float64
+	CumTotal   float64
+}
+
+// Cumulative runs go tool pprof on the supplied profiles, like FromProtoBuf,
+// and returns the cumulative weights of their functions, including those
+// inlined into others, and of their calls, inlined or not, both in
+// decreasing order of weight.  Only opts.Binary, opts.SampleType and
+// opts.Verbose apply.
+func Cumulative(profiles []string, opts Options) ([]*FuncItem, []*CallItem, error) {
+	p, countIndex, countTotal, err := readProtoBuf(profiles, opts)
+	if p == nil || err != nil {
+		return nil, nil, err
+	}
+
+	type callKey struct {
+		caller  FileLine
+		callee  string
+		inlined bool
+	}
+	funcs := make(map[string]*FuncItem)
+	calls := make(map[callKey]*CallItem)
+	var funcList []*FuncItem
+	var callList []*CallItem
+
+	for _, s := range p.Sample {
+		val := float64(s.Value[countIndex])
+		if val == 0 {
+			continue
+		}
+		// Each function and call is counted once per sample, even if recursive.
+		seenFuncs := make(map[string]bool)
+		seenCalls := make(map[callKey]bool)
+		call := func(caller, callee *profile.Line, inlined bool) {
+			if caller == nil || callee == nil || caller.Function == nil || callee.Function == nil {
+				return
+			}
+			k := callKey{FileLine{SourceFile: caller.Function.Filename, Line: caller.Line}, callee.Function.Name, inlined}
+			if seenCalls[k] {
+				return
+			}
+			seenCalls[k] = true
+			ci := calls[k]
+			if ci == nil {
+				ci = &CallItem{Caller: k.caller, CallerName: caller.Function.Name, Callee: k.callee, Inlined: inlined}
+				calls[k] = ci
+				callList = append(callList, ci)
+			}
+			ci.CumTotal += val
+		}
+		for i, loc := range s.Location {
+			for j, line := range loc.Line {
+				f := line.Function
+				if j+1 < len(loc.Line) {
+					// Inlined at the next (outer) line
+					call(&loc.Line[j+1], &loc.Line[j], true)
+				}
+				if f == nil || seenFuncs[f.Name] {
+					continue
+				}
+				seenFuncs[f.Name] = true
+				fi := funcs[f.Name]
+				if fi == nil {
+					fi = &FuncItem{Name: f.Name, SourceFile: f.Filename, StartLine: f.StartLine}
+					funcs[f.Name] = fi
+					funcList = append(funcList, fi)
+				}
+				fi.CumTotal += val
+			}
+			// The outermost function at this location was called, not inlined,
+			// from the innermost line of the next location.
+			if i+1 < len(s.Location) {
+				call(innermost(s.Location[i+1]), outermost(loc), false)
+			}
+		}
+	}
+
+	for _, fi := range funcList {
+		fi.CumPercent = 100 * fi.CumTotal / countTotal
+	}
+	for _, ci := range callList {
+		ci.CumPercent = 100 * ci.CumTotal / countTotal
+	}
+	sort.SliceStable(funcList, func(i, j int) bool { return funcList[i].CumTotal > funcList[j].CumTotal })
+	sort.SliceStable(callList, func(i, j int) bool { return callList[i].CumTotal > callList[j].CumTotal })
+	return funcList, callList, nil
+}
+
+// outermost returns the outermost line of loc, i.e., that of the function
+// that was actually called, or nil if there is none.
+func outermost(loc *profile.Location) *profile.Line {
+	if len(loc.Line) == 0 || loc.Line[len(loc.Line)-1].Function == nil {
+		return nil
+	}
+	return &loc.Line[len(loc.Line)-1]
+}
+
+// innermost returns the innermost line of loc, or nil if there is none.
+func innermost(loc *profile.Location) *profile.Line {
+	if len(loc.Line) == 0 {
+		return nil
+	}
+	return &loc.Line[0]
+}
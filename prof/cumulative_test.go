@@ -0,0 +1,111 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prof_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dr2chase/gc-lsp-tools/prof"
+	"github.com/google/pprof/profile"
+)
+
+func TestCumulative(t *testing.T) {
+	fn := func(id uint64, name, file string, start int64) *profile.Function {
+		return &profile.Function{ID: id, Name: name, SystemName: name, Filename: file, StartLine: start}
+	}
+	mainFn := fn(1, "main.main", "main.go", 10)
+	f := fn(2, "main.f", "f.go", 20)
+	g := fn(3, "main.g", "g.go", 30)
+	rec := fn(4, "main.rec", "rec.go", 40)
+
+	loc := func(id uint64, lines ...profile.Line) *profile.Location {
+		return &profile.Location{ID: id, Address: 0x1000 * id, Line: lines}
+	}
+	inMain := loc(1, profile.Line{Function: mainFn, Line: 12})
+	// g inlined into f at line 22
+	inF := loc(2, profile.Line{Function: g, Line: 31}, profile.Line{Function: f, Line: 22})
+	rec42 := loc(3, profile.Line{Function: rec, Line: 42})
+	rec43 := loc(4, profile.Line{Function: rec, Line: 43})
+
+	sample := func(n int64, stack ...*profile.Location) *profile.Sample {
+		return &profile.Sample{Location: stack, Value: []int64{n, n * 10000000}}
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     10000000,
+		Sample: []*profile.Sample{
+			sample(3, inF, inMain),
+			// Recursion: each function and call counts once per sample.
+			sample(2, rec42, rec43, rec42, inMain),
+			sample(1, rec42, rec43, rec42, rec43, rec42, inMain),
+			sample(4, inMain),
+		},
+		Location: []*profile.Location{inMain, inF, rec42, rec43},
+		Function: []*profile.Function{mainFn, f, g, rec},
+	}
+	file := filepath.Join(t.TempDir(), "cpu.prof")
+	out, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Write(out); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+
+	funcs, calls, err := prof.Cumulative([]string{file}, prof.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if funcs == nil {
+		t.Fatal("no functions; could not run go tool pprof?")
+	}
+
+	var gotFuncs []string
+	for _, fi := range funcs {
+		gotFuncs = append(gotFuncs, fmt.Sprintf("%s %s:%d %.0f%%", fi.Name, fi.SourceFile, fi.StartLine, fi.CumPercent))
+	}
+	sort.Strings(gotFuncs)
+	wantFuncs := []string{
+		"main.f f.go:20 30%",
+		"main.g g.go:30 30%",
+		"main.main main.go:10 100%",
+		"main.rec rec.go:40 30%",
+	}
+	if got, want := strings.Join(gotFuncs, "\n"), strings.Join(wantFuncs, "\n"); got != want {
+		t.Errorf("functions:\n%s\nwant:\n%s", got, want)
+	}
+
+	var gotCalls []string
+	for _, ci := range calls {
+		how := "called"
+		if ci.Inlined {
+			how = "inlined"
+		}
+		gotCalls = append(gotCalls, fmt.Sprintf("%s:%d %s %s %s %.0f%%", ci.Caller.SourceFile, ci.Caller.Line, ci.CallerName, how, ci.Callee, ci.CumPercent))
+	}
+	sort.Strings(gotCalls)
+	wantCalls := []string{
+		"f.go:22 main.f inlined main.g 30%",
+		"main.go:12 main.main called main.f 30%",
+		"main.go:12 main.main called main.rec 30%",
+		"rec.go:42 main.rec called main.rec 30%",
+		"rec.go:43 main.rec called main.rec 30%",
+	}
+	if got, want := strings.Join(gotCalls, "\n"), strings.Join(wantCalls, "\n"); got != want {
+		t.Errorf("calls:\n%s\nwant:\n%s", got, want)
+	}
+	for i := 1; i < len(funcs); i++ {
+		if funcs[i].CumTotal > funcs[i-1].CumTotal {
+			t.Errorf("functions not in decreasing order of weight: %s before %s", funcs[i-1].Name, funcs[i].Name)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prof_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dr2chase/gc-lsp-tools/prof"
+	"github.com/google/pprof/profile"
+)
+
+// genericSource is a package whose benchmark runs two instantiations of a
+// generic function.
+var genericSource = map[string]string{
+	"go.mod": "module example.com/gen\n\ngo 1.21\n",
+	"gen.go": `package gen
+
+//go:noinline
+func Sum[T int | float64](xs []T) (s T) {
+	for _, x := range xs {
+		s += x
+	}
+	return
+}
+`,
+	"gen_test.go": `package gen
+
+import "testing"
+
+func BenchmarkSum(b *testing.B) {
+	is := make([]int, 1000)
+	fs := make([]float64, 1000)
+	for i := 0; i < b.N; i++ {
+		Sum(is)
+		Sum(fs)
+	}
+}
+`,
+}
+
+func TestInstances(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a benchmark")
+	}
+	dir := t.TempDir()
+	for name, src := range genericSource {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// With -cpuprofile, go test keeps the test binary, gen.test.
+	cmd := exec.Command("go", "test", "-bench=Sum", "-benchtime=500ms", "-cpuprofile=cpu.prof", ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	cpu, binary := filepath.Join(dir, "cpu.prof"), filepath.Join(dir, "gen.test")
+
+	// instances returns the instantiations of Sum with samples in gen.go.
+	instances := func(profile string, opts prof.Options) []string {
+		t.Helper()
+		opts.Combine, opts.Instances = true, true
+		items, err := prof.FromProtoBufOptions([]string{profile}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := make(map[string]bool)
+		var names []string
+		for _, item := range items {
+			fl := item.FileLine[len(item.FileLine)-1]
+			if filepath.Base(fl.SourceFile) == "gen.go" && !seen[fl.Instance] {
+				seen[fl.Instance] = true
+				names = append(names, fl.Instance)
+			}
+		}
+		return names
+	}
+	check := func(what string, names []string) {
+		t.Helper()
+		got := strings.Join(names, " ")
+		for _, want := range []string{"example.com/gen.Sum[go.shape.int]", "example.com/gen.Sum[go.shape.float64]"} {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: instances %v, want %s", what, names, want)
+			}
+		}
+		if len(names) != 2 {
+			t.Errorf("%s: instances %v, want 2", what, names)
+		}
+	}
+	check("with the binary", instances(cpu, prof.Options{Binary: binary}))
+
+	// The runtime may name every instantiation as it prints them.
+	f, err := os.Open(cpu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := profile.Parse(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range p.Function {
+		if i := strings.Index(fn.Name, "["); i >= 0 {
+			fn.Name = fn.Name[:i] + "[...]"
+			fn.SystemName = fn.Name
+		}
+	}
+	printed := filepath.Join(dir, "printed.prof")
+	out, err := os.Create(printed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Write(out); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+
+	if _, err := prof.FromProtoBufOptions([]string{printed}, prof.Options{Combine: true, Instances: true}); err == nil || !strings.Contains(err.Error(), "Sum[...]") {
+		t.Errorf("instances named Sum[...] without the binary: error %v, want one naming Sum[...]", err)
+	}
+	check("re-symbolized", instances(printed, prof.Options{Binary: binary}))
+}
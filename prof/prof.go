@@ -11,11 +11,13 @@ import (
 	"os"
 	"os/exec"
 	"sort"
+	"strings"
 )
 
 type FileLine struct {
 	SourceFile string
 	Line       int64
+	Instance   string // if Options.Instances, the generic function instantiation containing Line, e.g. "pkg.F[go.shape.int]"
 }
 
 // ProfileItem represents one sample location, and provides the percentage
@@ -24,6 +26,24 @@ type ProfileItem struct {
 	FlatPercent float64
 	FlatTotal   float64
 	FileLine    []FileLine
+	Runs        []float64          // per-run FlatPercent, if merged from several runs by MergeRuns
+	Charged     map[string]float64 // with Options.Charge, the part of FlatTotal charged here from each runtime function
+}
+
+// ChargedPercent returns the percentage of all samples charged to p from
+// runtime functions matching patterns (see Options.Charge), or from any
+// runtime function if patterns is empty.
+func (p *ProfileItem) ChargedPercent(patterns []string) float64 {
+	if p.FlatTotal == 0 {
+		return 0
+	}
+	sum := 0.0
+	for fn, v := range p.Charged {
+		if len(patterns) == 0 || matchesAny(fn, patterns) {
+			sum += v
+		}
+	}
+	return p.FlatPercent * sum / p.FlatTotal
 }
 
 type ValueType struct {
@@ -39,21 +59,41 @@ type ValueType struct {
 // contained with, plus the Sample[*].Value index of the sample
 // count and the sum of those counts.
 func FileToSortedProfile(f *os.File, verbose int) (*profile.Profile, int, float64) {
-	p1, err := profile.Parse(f)
+	p, countIndex, countTotal, err := FileToSortedProfileType(f, "", verbose)
 	if err != nil {
 		panic(err)
 	}
+	return p, countIndex, countTotal
+}
+
+// FileToSortedProfileType is FileToSortedProfile, counting values of
+// sampleType (e.g., "alloc_objects") instead, if it is not empty.
+func FileToSortedProfileType(f *os.File, sampleType string, verbose int) (*profile.Profile, int, float64, error) {
+	p1, err := profile.Parse(f)
+	if err != nil {
+		return nil, 0, 0, err
+	}
 
 	countIndex := -1
 	for i, t := range p1.SampleType {
 		if verbose > 1 {
 			fmt.Fprintf(os.Stderr, "Sample type %d=%s\n", i, t.Type)
 		}
-		if t.Type == "samples" || t.Type == "alloc_space" {
+		if sampleType == "" && (t.Type == "samples" || t.Type == "alloc_space") || t.Type == sampleType {
 			countIndex = i
 			break
 		}
 	}
+	if countIndex < 0 {
+		var types []string
+		for _, t := range p1.SampleType {
+			types = append(types, t.Type)
+		}
+		if sampleType == "" {
+			sampleType = "samples or alloc_space"
+		}
+		return nil, 0, 0, fmt.Errorf("profile has no %s values, only %s", sampleType, strings.Join(types, ", "))
+	}
 
 	countTotal := 0.0
 	for _, s := range p1.Sample {
@@ -63,7 +103,7 @@ func FileToSortedProfile(f *os.File, verbose int) (*profile.Profile, int, float6
 	sort.Slice(p1.Sample, func(i, j int) bool {
 		return p1.Sample[i].Value[countIndex] < p1.Sample[j].Value[countIndex]
 	})
-	return p1, countIndex, countTotal
+	return p1, countIndex, countTotal, nil
 }
 
 type flsMap map[FileLine]struct {
@@ -101,11 +141,45 @@ func (m flsMap) get(s []FileLine) (index int, ok bool) {
 
 }
 
-// FromProtoBuf runs go tool pprof on the supplied profiles to generate
-// the (-flat, -lines) protobuf output, and then processes that protobuf
-// to yield a sorted profile of sample percentages and sample locations.
-// If combine is true, samples with equal file(s) and line(s) are merged.
-func FromProtoBuf(profiles []string, combine, innermost bool, verbose int) ([]*ProfileItem, error) {
+// Options controls how FromProtoBufOptions converts profile samples into ProfileItems.
+type Options struct {
+	Combine    bool     // merge samples with equal file(s) and line(s)
+	Innermost  bool     // use only the innermost file and line of each sample
+	Binary     string   // if not empty, symbolize locations lacking line information using this binary
+	Instances  bool     // record the instantiations of generic functions, keeping their samples apart, re-symbolizing with Binary if not empty
+	SampleType string   // if not empty, the type of sample value to count, e.g. "alloc_objects" (see FileToSortedProfileType)
+	Charge     []string // charge samples in these runtime functions to their callers (see ChargedFrame and DefaultCharged)
+	Verbose    int
+}
+
+// instance returns the name of f if it is an instantiation of a generic
+// function, e.g. "pkg.F[go.shape.int]", and opts asks for instances.
+func instance(f *profile.Function, opts Options) string {
+	if !opts.Instances || f == nil || !strings.Contains(f.Name, "[") {
+		return ""
+	}
+	return f.Name
+}
+
+func (p *ProfileItem) charge(from string, val float64) {
+	if from == "" {
+		return
+	}
+	if p.Charged == nil {
+		p.Charged = make(map[string]float64)
+	}
+	p.Charged[from] += val
+}
+
+// readProtoBuf runs go tool pprof on the supplied profiles to generate the
+// (-flat, -lines) protobuf output, which retains the samples' full stacks,
+// and reads it, symbolizing it if opts.Binary is not empty (see
+// FileToSortedProfileType for the other results).  If go tool pprof fails,
+// its output is printed and the profile is nil.  With opts.Instances, every
+// location is symbolized anew, and it is an error if some generic function
+// is still named without its shapes.
+func readProtoBuf(profiles []string, opts Options) (*profile.Profile, int, float64, error) {
+	verbose := opts.Verbose
 	tempFile, err := ioutil.TempFile("", "profile.*.pb.gz")
 	if err != nil {
 		panic(err)
@@ -126,10 +200,57 @@ func FromProtoBuf(profiles []string, combine, innermost bool, verbose int) ([]*P
 		fmt.Printf("Failed to run go%s\n", m)
 		fmt.Println(string(out))
 		fmt.Println(err)
-		return nil, nil
+		return nil, 0, 0, nil
 	}
 
-	p, countIndex, countTotal := FileToSortedProfile(tempFile, verbose)
+	p, countIndex, countTotal, err := FileToSortedProfileType(tempFile, opts.SampleType, verbose)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	if opts.Binary != "" {
+		sym, err := NewSymbolizer(opts.Binary)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		var n int
+		if opts.Instances {
+			n = sym.Resymbolize(p)
+		} else {
+			n = sym.Symbolize(p)
+		}
+		sym.Close()
+		if verbose > 0 {
+			fmt.Fprintf(os.Stderr, "Symbolized %d locations using %s\n", n, opts.Binary)
+		}
+	}
+	if opts.Instances {
+		for _, loc := range p.Location {
+			for _, line := range loc.Line {
+				if f := line.Function; f != nil && strings.Contains(f.Name, "[...]") {
+					return nil, 0, 0, fmt.Errorf("the profile names %s without the shapes of its instantiations; symbolize it with the profiled binary (see Options.Binary)", f.Name)
+				}
+			}
+		}
+	}
+	return p, countIndex, countTotal, nil
+}
+
+// FromProtoBuf runs go tool pprof on the supplied profiles to generate
+// the (-flat, -lines) protobuf output, and then processes that protobuf
+// to yield a sorted profile of sample percentages and sample locations.
+// If combine is true, samples with equal file(s) and line(s) are merged.
+func FromProtoBuf(profiles []string, combine, innermost bool, verbose int) ([]*ProfileItem, error) {
+	return FromProtoBufOptions(profiles, Options{Combine: combine, Innermost: innermost, Verbose: verbose})
+}
+
+// FromProtoBufOptions is FromProtoBuf, with the additional choices in opts.
+func FromProtoBufOptions(profiles []string, opts Options) ([]*ProfileItem, error) {
+	combine, innermost := opts.Combine, opts.Innermost
+	p, countIndex, countTotal, err := readProtoBuf(profiles, opts)
+	if p == nil || err != nil {
+		return nil, err
+	}
 
 	flsmap := make(flsMap)
 
@@ -142,6 +263,13 @@ func FromProtoBuf(profiles []string, combine, innermost bool, verbose int) ([]*P
 		val := float64(s.Value[countIndex])
 		c := val / countTotal
 		lines := s.Location[0].Line
+		from := ""
+		if len(opts.Charge) > 0 {
+			var loc, line int
+			if loc, line, from = ChargedFrame(s, opts.Charge); from != "" {
+				lines = s.Location[loc].Line[line:]
+			}
+		}
 		l := len(lines)
 		if l == 0 {
 			continue
@@ -151,6 +279,7 @@ func FromProtoBuf(profiles []string, combine, innermost bool, verbose int) ([]*P
 			fileLines = []FileLine{{
 				SourceFile: lines[0].Function.Filename,
 				Line:       lines[0].Line,
+				Instance:   instance(lines[0].Function, opts),
 			}}
 		} else {
 			fileLines = make([]FileLine, l, l)
@@ -158,6 +287,7 @@ func FromProtoBuf(profiles []string, combine, innermost bool, verbose int) ([]*P
 				fileLines[l-i-1] = FileLine{
 					SourceFile: line.Function.Filename,
 					Line:       line.Line,
+					Instance:   instance(line.Function, opts),
 				}
 			}
 		}
@@ -170,16 +300,19 @@ func FromProtoBuf(profiles []string, combine, innermost bool, verbose int) ([]*P
 			if ok {
 				pi[i].FlatTotal += val
 				pi[i].FlatPercent += 100 * c
+				pi[i].charge(from, val)
 				continue
 			}
 			flsmap.put(fileLines, len(pi))
 		}
 
-		pi = append(pi, &ProfileItem{
+		p := &ProfileItem{
 			FlatPercent: 100 * c,
 			FlatTotal:   val,
 			FileLine:    fileLines,
-		})
+		}
+		p.charge(from, val)
+		pi = append(pi, p)
 	}
 
 	if combine {
@@ -0,0 +1,129 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prof
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Z returns the two-sided normal quantile for a confidence level,
+// for example 1.96 for 0.95.
+func Z(confidence float64) float64 {
+	return math.Sqrt2 * math.Erfinv(confidence)
+}
+
+// SampleTotal returns the total sample count of the profile that p came from.
+func (p *ProfileItem) SampleTotal() float64 {
+	if p.FlatPercent == 0 {
+		return 0
+	}
+	return 100 * p.FlatTotal / p.FlatPercent
+}
+
+// Interval returns a confidence interval, in percent, for the true
+// fraction of samples at p, at the given confidence level (e.g., 0.95).
+// This treats FlatTotal as a count of independent samples and uses the
+// Wilson score interval, which behaves sensibly for small counts.
+// If p was merged from several runs, the interval is widened as necessary
+// to also cover the run-to-run variation of FlatPercent.
+// The result is meaningless for sample types that are not counts,
+// for example alloc_space.
+func (p *ProfileItem) Interval(confidence float64) (lo, hi float64) {
+	z := Z(confidence)
+	n := p.SampleTotal()
+	if n == 0 {
+		return 0, 100
+	}
+	f := p.FlatTotal / n
+	z2n := z * z / n
+	denom := 1 + z2n
+	center := (f + z2n/2) / denom
+	half := z * math.Sqrt(f*(1-f)/n+z2n/(4*n)) / denom
+	lo, hi = 100*(center-half), 100*(center+half)
+
+	if k := len(p.Runs); k > 1 {
+		mean, sd := p.RunMean(), p.RunStdDev()
+		h := z * sd / math.Sqrt(float64(k))
+		lo, hi = math.Min(lo, mean-h), math.Max(hi, mean+h)
+	}
+	return math.Max(lo, 0), math.Min(hi, 100)
+}
+
+// RunMean returns the mean of the per-run percentages of p,
+// or FlatPercent if p was not merged from several runs.
+func (p *ProfileItem) RunMean() float64 {
+	if len(p.Runs) == 0 {
+		return p.FlatPercent
+	}
+	sum := 0.0
+	for _, r := range p.Runs {
+		sum += r
+	}
+	return sum / float64(len(p.Runs))
+}
+
+// RunStdDev returns the sample standard deviation of the per-run percentages
+// of p, or zero if there were fewer than two runs.
+func (p *ProfileItem) RunStdDev() float64 {
+	k := len(p.Runs)
+	if k < 2 {
+		return 0
+	}
+	mean := p.RunMean()
+	ss := 0.0
+	for _, r := range p.Runs {
+		ss += (r - mean) * (r - mean)
+	}
+	return math.Sqrt(ss / float64(k-1))
+}
+
+func fileLinesKey(fls []FileLine) string {
+	var sb strings.Builder
+	for _, fl := range fls {
+		fmt.Fprintf(&sb, "%s:%d:%s;", fl.SourceFile, fl.Line, fl.Instance)
+	}
+	return sb.String()
+}
+
+// MergeRuns combines the results of several runs of the same program
+// (for example, one profile per run of a benchmark) into a single profile.
+// FlatTotal is summed, FlatPercent is computed from the pooled samples, and
+// Runs records each run's percentage (zero if the location did not appear in
+// that run).  The result is sorted in increasing FlatPercent, like the output
+// of FromProtoBuf with combining.
+func MergeRuns(runs [][]*ProfileItem) []*ProfileItem {
+	byKey := make(map[string]*ProfileItem)
+	var merged []*ProfileItem
+	grandTotal := 0.0
+	for r, run := range runs {
+		if len(run) > 0 {
+			grandTotal += run[0].SampleTotal()
+		}
+		for _, p := range run {
+			k := fileLinesKey(p.FileLine)
+			m := byKey[k]
+			if m == nil {
+				m = &ProfileItem{FileLine: p.FileLine, Runs: make([]float64, len(runs))}
+				byKey[k] = m
+				merged = append(merged, m)
+			}
+			m.FlatTotal += p.FlatTotal
+			m.Runs[r] += p.FlatPercent
+			for fn, v := range p.Charged {
+				m.charge(fn, v)
+			}
+		}
+	}
+	for _, m := range merged {
+		if grandTotal > 0 {
+			m.FlatPercent = 100 * m.FlatTotal / grandTotal
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].FlatPercent < merged[j].FlatPercent })
+	return merged
+}
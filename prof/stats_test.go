@@ -0,0 +1,64 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prof_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/dr2chase/gc-lsp-tools/prof"
+)
+
+func item(count, total float64, file string, line int64) *prof.ProfileItem {
+	return &prof.ProfileItem{
+		FlatTotal:   count,
+		FlatPercent: 100 * count / total,
+		FileLine:    []prof.FileLine{{SourceFile: file, Line: line}},
+	}
+}
+
+func TestInterval(t *testing.T) {
+	if z := prof.Z(0.95); math.Abs(z-1.96) > 0.01 {
+		t.Errorf("Z(0.95) = %f, want 1.96", z)
+	}
+
+	// The same 1.1% is much less certain with 12 samples than with 12000.
+	few := item(12, 1091, "a.go", 1)
+	many := item(12000, 1091*1000, "a.go", 1)
+	flo, fhi := few.Interval(0.95)
+	mlo, mhi := many.Interval(0.95)
+	if !(flo < mlo && mhi < fhi) {
+		t.Errorf("few samples [%f, %f] should contain many samples [%f, %f]", flo, fhi, mlo, mhi)
+	}
+	if !(flo < 1.0 && fhi > 1.0) {
+		t.Errorf("few samples [%f, %f] should overlap 1%%", flo, fhi)
+	}
+	if !(mlo > 1.0) {
+		t.Errorf("many samples [%f, %f] should be above 1%%", mlo, mhi)
+	}
+}
+
+func TestMergeRuns(t *testing.T) {
+	run1 := []*prof.ProfileItem{item(10, 100, "a.go", 1), item(90, 100, "a.go", 2)}
+	run2 := []*prof.ProfileItem{item(30, 100, "a.go", 1), item(70, 100, "a.go", 2)}
+	run3 := []*prof.ProfileItem{item(100, 100, "a.go", 2)}
+	merged := prof.MergeRuns([][]*prof.ProfileItem{run1, run2, run3})
+	if len(merged) != 2 {
+		t.Fatalf("len(merged) = %d, want 2", len(merged))
+	}
+	a1, a2 := merged[0], merged[1]
+	if a1.FileLine[0].Line != 1 || a2.FileLine[0].Line != 2 {
+		t.Errorf("merged not sorted by percent: %v, %v", a1.FileLine, a2.FileLine)
+	}
+	if a1.FlatTotal != 40 || math.Abs(a1.FlatPercent-40.0/3) > 1e-9 {
+		t.Errorf("a.go:1 total, percent = %f, %f, want 40, 13.33", a1.FlatTotal, a1.FlatPercent)
+	}
+	if want := []float64{10, 30, 0}; len(a1.Runs) != 3 || a1.Runs[0] != want[0] || a1.Runs[1] != want[1] || a1.Runs[2] != want[2] {
+		t.Errorf("a.go:1 runs = %v, want %v", a1.Runs, want)
+	}
+	if sd := a1.RunStdDev(); math.Abs(sd-math.Sqrt(700.0/3)) > 1e-9 {
+		t.Errorf("a.go:1 stddev = %f, want %f", sd, math.Sqrt(700.0/3))
+	}
+}
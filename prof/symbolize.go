@@ -0,0 +1,392 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prof
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"debug/gosym"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// A Symbolizer maps addresses in a profiled binary to file, line and function,
+// including any inlined frames. It uses the binary's DWARF if present, and
+// otherwise falls back on the Go pclntab; debug/gosym does not decode inlining,
+// so in that case only the outermost function is reported, with the innermost
+// file and line.
+type Symbolizer struct {
+	binary  string
+	buildID string
+	f       *elf.File
+	dynamic bool // position independent, addresses must be adjusted by mapping.
+
+	// From DWARF, if present
+	dwarf *dwarf.Data
+	subs  []*subprogram // sorted by lowpc
+	lines map[dwarf.Offset]*cuLines
+
+	// From pclntab, if DWARF is not present
+	table *gosym.Table
+}
+
+// subprogram is a DWARF function, with any functions inlined into it.
+type subprogram struct {
+	name        string
+	lowpc, hipc uint64
+	cu          dwarf.Offset
+	inlines     []*inlined // preorder, so callers precede their callees
+}
+
+// inlined is a DWARF inlined subroutine, called from callFile:callLine
+// in its enclosing subprogram or inlined subroutine.
+type inlined struct {
+	name     string
+	ranges   [][2]uint64
+	depth    int
+	callFile string
+	callLine int64
+}
+
+type cuLines struct {
+	r     *dwarf.LineReader
+	files []*dwarf.LineFile
+}
+
+func (i *inlined) contains(pc uint64) bool {
+	for _, r := range i.ranges {
+		if r[0] <= pc && pc < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSymbolizer opens the ELF binary and reads the tables needed to symbolize
+// addresses within it.
+func NewSymbolizer(binary string) (*Symbolizer, error) {
+	f, err := elf.Open(binary)
+	if err != nil {
+		return nil, err
+	}
+	s := &Symbolizer{binary: binary, f: f, dynamic: f.Type == elf.ET_DYN}
+	s.buildID = goBuildID(f)
+
+	if d, err := f.DWARF(); err == nil {
+		if err = s.readDWARF(d); err == nil {
+			return s, nil
+		}
+	}
+
+	if err := s.readPclntab(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s has neither usable DWARF nor pclntab: %v", binary, err)
+	}
+	return s, nil
+}
+
+// Close releases the binary opened by NewSymbolizer.
+func (s *Symbolizer) Close() error {
+	return s.f.Close()
+}
+
+// BuildID returns the Go build ID of the binary, if it has one.
+func (s *Symbolizer) BuildID() string {
+	return s.buildID
+}
+
+func (s *Symbolizer) readDWARF(d *dwarf.Data) error {
+	s.dwarf = d
+	s.lines = make(map[dwarf.Offset]*cuLines)
+	r := d.Reader()
+	var cu dwarf.Offset
+	var sub *subprogram
+	depth := 0 // nesting depth within sub, counting only inlined subroutines
+	var open []int
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag == 0 { // end of children
+			if len(open) > 0 {
+				depth = open[len(open)-1]
+				open = open[:len(open)-1]
+			}
+			continue
+		}
+		switch e.Tag {
+		case dwarf.TagCompileUnit:
+			cu = e.Offset
+			sub = nil
+			depth = 0
+			open = open[:0]
+			if e.Children {
+				open = append(open, depth)
+			}
+			continue
+
+		case dwarf.TagSubprogram:
+			sub = nil
+			depth = 0
+			ranges, err := d.Ranges(e)
+			if err == nil && len(ranges) > 0 {
+				sub = &subprogram{name: entryName(d, e), lowpc: ranges[0][0], hipc: ranges[0][1], cu: cu}
+				s.subs = append(s.subs, sub)
+			}
+
+		case dwarf.TagInlinedSubroutine:
+			if sub != nil {
+				ranges, err := d.Ranges(e)
+				if err == nil && len(ranges) > 0 {
+					line, _ := e.Val(dwarf.AttrCallLine).(int64)
+					fileIndex, _ := e.Val(dwarf.AttrCallFile).(int64)
+					sub.inlines = append(sub.inlines, &inlined{
+						name:     entryName(d, e),
+						ranges:   ranges,
+						depth:    depth,
+						callFile: s.fileName(d, cu, fileIndex),
+						callLine: line,
+					})
+				}
+				if e.Children {
+					open = append(open, depth)
+					depth++
+				}
+				continue
+			}
+		}
+		if e.Children {
+			open = append(open, depth)
+		}
+	}
+	if len(s.subs) == 0 {
+		return fmt.Errorf("no functions in DWARF")
+	}
+	sort.Slice(s.subs, func(i, j int) bool { return s.subs[i].lowpc < s.subs[j].lowpc })
+	return nil
+}
+
+// entryName returns the name of a DWARF subprogram or inlined subroutine,
+// following its abstract origin if necessary.
+func entryName(d *dwarf.Data, e *dwarf.Entry) string {
+	if name, ok := e.Val(dwarf.AttrName).(string); ok {
+		return name
+	}
+	if off, ok := e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset); ok {
+		r := d.Reader()
+		r.Seek(off)
+		if o, err := r.Next(); err == nil && o != nil {
+			if name, ok := o.Val(dwarf.AttrName).(string); ok {
+				return name
+			}
+		}
+	}
+	return "?"
+}
+
+// cuLineReader returns the (cached) line reader for the compilation unit at offset cu.
+func (s *Symbolizer) cuLineReader(d *dwarf.Data, cu dwarf.Offset) *cuLines {
+	if l, ok := s.lines[cu]; ok {
+		return l
+	}
+	r := d.Reader()
+	r.Seek(cu)
+	var l *cuLines
+	if e, err := r.Next(); err == nil && e != nil {
+		if lr, err := d.LineReader(e); err == nil && lr != nil {
+			l = &cuLines{r: lr, files: lr.Files()}
+		}
+	}
+	s.lines[cu] = l
+	return l
+}
+
+func (s *Symbolizer) fileName(d *dwarf.Data, cu dwarf.Offset, index int64) string {
+	l := s.cuLineReader(d, cu)
+	if l == nil || index < 0 || int(index) >= len(l.files) || l.files[index] == nil {
+		return "?"
+	}
+	return l.files[index].Name
+}
+
+func (s *Symbolizer) readPclntab() error {
+	pclntab := s.f.Section(".gopclntab")
+	text := s.f.Section(".text")
+	if pclntab == nil || text == nil {
+		return fmt.Errorf("missing .gopclntab or .text")
+	}
+	data, err := pclntab.Data()
+	if err != nil {
+		return err
+	}
+	var symtab []byte
+	if sym := s.f.Section(".gosymtab"); sym != nil {
+		symtab, _ = sym.Data()
+	}
+	s.table, err = gosym.NewTable(symtab, gosym.NewLineTable(data, text.Addr))
+	return err
+}
+
+// Frames returns the file, line and function for the address addr, innermost
+// first, in the same order as profile.Location.Line.  m is the mapping that
+// contains addr; if nil, addr is assumed to be unrelocated.
+// If addr cannot be symbolized, Frames returns nil.
+func (s *Symbolizer) Frames(addr uint64, m *profile.Mapping) []profile.Line {
+	pc := s.adjust(addr, m)
+	if s.dwarf != nil {
+		return s.dwarfFrames(pc)
+	}
+	file, line, fn := s.table.PCToLine(pc)
+	if fn == nil {
+		return nil
+	}
+	return []profile.Line{{Function: &profile.Function{Name: fn.Name, SystemName: fn.Name, Filename: file}, Line: int64(line)}}
+}
+
+func (s *Symbolizer) dwarfFrames(pc uint64) []profile.Line {
+	i := sort.Search(len(s.subs), func(i int) bool { return s.subs[i].hipc > pc })
+	if i == len(s.subs) || pc < s.subs[i].lowpc {
+		return nil
+	}
+	sub := s.subs[i]
+	l := s.cuLineReader(s.dwarf, sub.cu)
+	if l == nil {
+		return nil
+	}
+	var le dwarf.LineEntry
+	if err := l.r.SeekPC(pc, &le); err != nil {
+		return nil
+	}
+
+	// The inlines are in preorder, so the chain of those containing pc
+	// appears outermost first with increasing depth.
+	var chain []*inlined
+	for _, in := range sub.inlines {
+		if in.depth == len(chain) && in.contains(pc) {
+			chain = append(chain, in)
+		}
+	}
+
+	frames := make([]profile.Line, 0, len(chain)+1)
+	file, line := le.File.Name, int64(le.Line)
+	for j := len(chain) - 1; j >= 0; j-- {
+		in := chain[j]
+		frames = append(frames, profile.Line{Function: &profile.Function{Name: in.name, SystemName: in.name, Filename: file}, Line: line})
+		file, line = in.callFile, in.callLine
+	}
+	frames = append(frames, profile.Line{Function: &profile.Function{Name: sub.name, SystemName: sub.name, Filename: file}, Line: line})
+	return frames
+}
+
+// adjust converts a runtime address in mapping m to a virtual address in the binary.
+func (s *Symbolizer) adjust(addr uint64, m *profile.Mapping) uint64 {
+	if !s.dynamic || m == nil {
+		return addr
+	}
+	off := addr - m.Start + m.Offset
+	for _, p := range s.f.Progs {
+		if p.Type == elf.PT_LOAD && p.Off <= off && off < p.Off+p.Filesz {
+			return off - p.Off + p.Vaddr
+		}
+	}
+	return off
+}
+
+// owns reports whether the mapping m (of profile p) is for this binary.
+// Lacking better information, the first mapping is assumed to be the main binary.
+func (s *Symbolizer) owns(p *profile.Profile, m *profile.Mapping) bool {
+	if m == nil {
+		return true
+	}
+	if s.buildID != "" && m.BuildID == s.buildID {
+		return true
+	}
+	if m.File != "" && filepath.Base(m.File) == filepath.Base(s.binary) {
+		return true
+	}
+	return len(p.Mapping) > 0 && m == p.Mapping[0]
+}
+
+// Symbolize adds file, line and function information to those locations
+// in p that lack it and that belong to the binary.  It returns the
+// number of locations that were symbolized.
+func (s *Symbolizer) Symbolize(p *profile.Profile) int {
+	return s.symbolize(p, false)
+}
+
+// Resymbolize is Symbolize, but replaces the information that locations
+// already have, too.  The binary names instantiations of generic functions
+// by their shapes, e.g. "pkg.F[go.shape.int]", but a profile may name them
+// as the runtime prints them, "pkg.F[...]".
+func (s *Symbolizer) Resymbolize(p *profile.Profile) int {
+	return s.symbolize(p, true)
+}
+
+func (s *Symbolizer) symbolize(p *profile.Profile, all bool) int {
+	type fnKey struct{ name, file string }
+	functions := make(map[fnKey]*profile.Function)
+	for _, f := range p.Function {
+		functions[fnKey{f.Name, f.Filename}] = f
+	}
+	n := 0
+	for _, loc := range p.Location {
+		if len(loc.Line) > 0 && !all || !s.owns(p, loc.Mapping) {
+			continue
+		}
+		frames := s.Frames(loc.Address, loc.Mapping)
+		if len(frames) == 0 {
+			continue
+		}
+		// Share functions, so that the profile remains well-formed.
+		for i, fr := range frames {
+			k := fnKey{fr.Function.Name, fr.Function.Filename}
+			f := functions[k]
+			if f == nil {
+				f = fr.Function
+				f.ID = uint64(len(p.Function) + 1)
+				p.Function = append(p.Function, f)
+				functions[k] = f
+			}
+			frames[i].Function = f
+		}
+		loc.Line = frames
+		n++
+	}
+	if n > 0 {
+		for _, m := range p.Mapping {
+			if s.owns(p, m) {
+				m.HasFunctions, m.HasFilenames, m.HasLineNumbers = true, true, true
+				m.HasInlineFrames = s.dwarf != nil
+			}
+		}
+	}
+	return n
+}
+
+// goBuildID returns the Go build ID note of an ELF file, or "" if none.
+func goBuildID(f *elf.File) string {
+	sect := f.Section(".note.go.buildid")
+	if sect == nil {
+		return ""
+	}
+	data, err := sect.Data()
+	if err != nil || len(data) < 16 {
+		return ""
+	}
+	nameSize := f.ByteOrder.Uint32(data[0:])
+	descSize := f.ByteOrder.Uint32(data[4:])
+	start := 12 + (nameSize+3)&^3
+	if uint64(start)+uint64(descSize) > uint64(len(data)) {
+		return ""
+	}
+	return string(data[start : start+descSize])
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prof_test
+
+import (
+	"debug/elf"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/dr2chase/gc-lsp-tools/prof"
+	"github.com/google/pprof/profile"
+)
+
+//go:noinline
+func symbolizeMe(x []int) int {
+	return x[len(x)-1] + 1
+}
+
+// inlinee is inlined into inliner, so some of inliner's pcs have two frames.
+func inlinee(x []int) int {
+	return x[len(x)-1]
+}
+
+//go:noinline
+func inliner(x []int) int {
+	return inlinee(x) + 1
+}
+
+func TestSymbolizer(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	hasDWARF := false
+	if f, err := elf.Open(exe); err != nil {
+		t.Skipf("not ELF: %v", err)
+	} else {
+		dynamic := f.Type == elf.ET_DYN
+		hasDWARF = f.Section(".debug_info") != nil
+		f.Close()
+		if dynamic {
+			t.Skip("position-independent test binary")
+		}
+	}
+
+	sym, err := prof.NewSymbolizer(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sym.Close()
+
+	pc := uint64(reflect.ValueOf(symbolizeMe).Pointer())
+	fn := runtime.FuncForPC(uintptr(pc))
+	wantFile, wantLine := fn.FileLine(uintptr(pc))
+
+	frames := sym.Frames(pc, nil)
+	if len(frames) == 0 {
+		t.Fatalf("no frames for %s at %#x", fn.Name(), pc)
+	}
+	inner := frames[0]
+	if !strings.HasSuffix(inner.Function.Name, "symbolizeMe") {
+		t.Errorf("function = %s, want ...symbolizeMe", inner.Function.Name)
+	}
+	if inner.Function.Filename != wantFile || inner.Line != int64(wantLine) {
+		t.Errorf("position = %s:%d, want %s:%d", inner.Function.Filename, inner.Line, wantFile, wantLine)
+	}
+
+	// Pcs where inlinee is inlined into inliner should have both frames,
+	// but that requires DWARF, which go test usually omits.
+	entry := reflect.ValueOf(inliner).Pointer()
+	found := false
+	for pc := entry; hasDWARF && pc < entry+256 && runtime.FuncForPC(pc).Entry() == entry; pc++ {
+		inner := runtime.FuncForPC(pc).Name() // the innermost function, for inlined pcs.
+		if !strings.HasSuffix(inner, ".inlinee") {
+			continue
+		}
+		found = true
+		var got []string
+		for _, f := range sym.Frames(uint64(pc), nil) {
+			got = append(got, f.Function.Name)
+		}
+		want := []string{inner, runtime.FuncForPC(entry).Name()}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("inline frames at %#x = %v, want %v", pc, got, want)
+		}
+		break
+	}
+	if hasDWARF && !found {
+		t.Logf("inlinee was not inlined into inliner")
+	}
+	if inliner([]int{1}) != 2 {
+		t.Errorf("inliner is broken")
+	}
+
+	// A profile location with no lines should be filled in.
+	m := &profile.Mapping{ID: 1, File: exe}
+	loc := &profile.Location{ID: 1, Mapping: m, Address: pc}
+	p := &profile.Profile{Mapping: []*profile.Mapping{m}, Location: []*profile.Location{loc}}
+	if n := sym.Symbolize(p); n != 1 {
+		t.Errorf("Symbolize returned %d, want 1", n)
+	}
+	if len(loc.Line) == 0 || loc.Line[0].Function.ID == 0 {
+		t.Errorf("location not symbolized, or function not added to profile: %+v", loc.Line)
+	}
+	if symbolizeMe([]int{1}) != 2 {
+		t.Errorf("symbolizeMe is broken")
+	}
+}